@@ -2,8 +2,16 @@ package client
 
 import (
 	"context"
+	"errors"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pghq/go-tea"
 )
@@ -14,29 +22,268 @@ const (
 
 	// UserAgent is the default user agent for outgoing requests
 	UserAgent = "go-way/v" + Version
+
+	// DefaultRetries is the default number of retries for transient failures
+	DefaultRetries = 3
+
+	// DefaultBackoff is the default wait time before the first retry
+	DefaultBackoff = time.Second
+
+	// DefaultMaxBackoff is the default maximum wait time between retries (Retry-After may ask for longer)
+	DefaultMaxBackoff = 30 * time.Second
 )
 
+// ErrNotModified is returned when a conditional request finds the resource unchanged
+var ErrNotModified = errors.New("not modified")
+
+// Validator identifies a version of a remote resource
+type Validator struct {
+	ETag         string
+	LastModified string
+}
+
+// IsZero checks if the validator is empty
+func (v Validator) IsZero() bool {
+	return v == Validator{}
+}
+
+// NewValidator creates a validator from a response
+func NewValidator(resp *http.Response) Validator {
+	return Validator{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// Option to configure requests
+type Option func(o *options)
+
+// Since makes the request conditional on the resource having changed since the validator
+func Since(v Validator) Option {
+	return func(o *options) {
+		o.since = v
+	}
+}
+
+// Retries sets the maximum number of retries for transient failures (5xx and 429 responses, connection resets and timeouts)
+// the response body is handed to the caller as is, so failures while reading it are not retried
+func Retries(n int) Option {
+	return func(o *options) {
+		o.retries = n
+	}
+}
+
+// Backoff sets the wait time before the first retry, which doubles (with jitter) for each retry up to the maximum
+func Backoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.backoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// HTTPClient sets a custom http client for requests (e.g., with a custom transport or proxy)
+func HTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// Header adds a header to requests, replacing any default value (e.g., User-Agent)
+func Header(key, value string) Option {
+	return func(o *options) {
+		if o.header == nil {
+			o.header = make(http.Header)
+		}
+
+		o.header.Add(key, value)
+	}
+}
+
+// BasicAuth authenticates requests with a user and password (e.g., a MaxMind account id and license key)
+// credentials are not forwarded to other hosts on redirects
+func BasicAuth(user, password string) Option {
+	return func(o *options) {
+		o.user, o.password = user, password
+	}
+}
+
+// options for requests
+type options struct {
+	since      Validator
+	client     *http.Client
+	header     http.Header
+	user       string
+	password   string
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// delay before a retry, honoring the Retry-After header of a response
+func (o options) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d
+		}
+	}
+
+	d := o.backoff
+	for i := 0; i < attempt && d < o.maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > o.maxBackoff {
+		d = o.maxBackoff
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
 // Get http request
-func Get(ctx context.Context, url string) (*http.Response, error) {
-	return do(ctx, http.MethodGet, url, nil)
+func Get(ctx context.Context, url string, opts ...Option) (*http.Response, error) {
+	return do(ctx, http.MethodGet, url, nil, opts...)
 }
 
 // do a http request
-func do(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
-	r, err := http.NewRequestWithContext(ctx, method, url, body)
+func do(ctx context.Context, method, uri string, body io.Reader, opts ...Option) (*http.Response, error) {
+	o := options{retries: DefaultRetries, backoff: DefaultBackoff, maxBackoff: DefaultMaxBackoff}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, uri, body)
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, tea.Stacktrace(redactError(err))
 	}
 
 	r.Header.Set("User-Agent", UserAgent)
-	resp, err := http.DefaultClient.Do(r)
+	for key, values := range o.header {
+		r.Header[key] = append([]string{}, values...)
+	}
+
+	if o.user != "" || o.password != "" {
+		r.SetBasicAuth(o.user, o.password)
+	}
+
+	if o.since.ETag != "" {
+		r.Header.Set("If-None-Match", o.since.ETag)
+	}
+
+	if o.since.LastModified != "" {
+		r.Header.Set("If-Modified-Since", o.since.LastModified)
+	}
+
+	hc := o.client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		resp, err = hc.Do(r)
+		if attempt >= o.retries || ctx.Err() != nil || !transient(resp, err) {
+			break
+		}
+
+		delay := o.delay(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, tea.Stacktrace(ctx.Err())
+		}
+	}
+
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, tea.Stacktrace(redactError(err))
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		return nil, tea.Stacktrace(ErrNotModified)
 	}
 
 	if resp.StatusCode != 200 {
+		_ = resp.Body.Close()
 		return nil, tea.Errf("unexpected refresh response code %d", resp.StatusCode)
 	}
 
 	return resp, nil
 }
+
+// transient checks if a failed request may succeed if retried
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		var nerr net.Error
+		return errors.As(err, &nerr) && nerr.Timeout() ||
+			errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500
+}
+
+// retryAfter parses a Retry-After header (delay in seconds or a http date)
+func retryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// Redact secrets (passwords and key, token or secret query parameters) from a url
+func Redact(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "REDACTED"
+	}
+
+	if _, present := u.User.Password(); present {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+
+	query := u.Query()
+	for key := range query {
+		name := strings.ToLower(key)
+		if strings.Contains(name, "key") || strings.Contains(name, "token") || strings.Contains(name, "secret") {
+			query.Set(key, "REDACTED")
+		}
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// redactError redacts secrets from the url of a request error
+func redactError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return &url.Error{Op: uerr.Op, URL: Redact(uerr.URL), Err: uerr.Err}
+	}
+
+	return err
+}
@@ -2,10 +2,15 @@ package client
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pghq/go-tea"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,9 +38,174 @@ func TestGet(t *testing.T) {
 		assert.NotNil(t, err)
 	})
 
+	t.Run("not modified", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") == "Mon, 15 Nov 2021 00:52:00 GMT" {
+				w.WriteHeader(http.StatusNotModified)
+			}
+		}))
+
+		_, err := Get(context.TODO(), s.URL, Since(Validator{ETag: `"v1"`, LastModified: "Mon, 15 Nov 2021 00:52:00 GMT"}))
+		assert.NotNil(t, err)
+		assert.True(t, tea.IsError(err, ErrNotModified))
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v2"`)
+			w.Header().Set("Last-Modified", "Tue, 16 Nov 2021 00:52:00 GMT")
+		}))
+
+		resp, err := Get(context.TODO(), s.URL, Since(Validator{ETag: `"v1"`}))
+		assert.Nil(t, err)
+		assert.Equal(t, Validator{ETag: `"v2"`, LastModified: "Tue, 16 Nov 2021 00:52:00 GMT"}, NewValidator(resp))
+		assert.False(t, NewValidator(resp).IsZero())
+	})
+
+	t.Run("retries transient failures", func(t *testing.T) {
+		var hits int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch atomic.AddInt32(&hits, 1) {
+			case 1:
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			case 2:
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+
+		_, err := Get(context.TODO(), s.URL, Backoff(time.Millisecond, time.Millisecond))
+		assert.Nil(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		var hits int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+
+		_, err := Get(context.TODO(), s.URL, Retries(2), Backoff(time.Millisecond, time.Millisecond))
+		assert.NotNil(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		var hits int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.WriteHeader(http.StatusForbidden)
+		}))
+
+		_, err := Get(context.TODO(), s.URL, Backoff(time.Millisecond, time.Millisecond))
+		assert.NotNil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	})
+
+	t.Run("honors retry after", func(t *testing.T) {
+		var hits int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+
+		start := time.Now()
+		_, err := Get(context.TODO(), s.URL, Backoff(time.Millisecond, time.Millisecond))
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		defer cancel()
+		atomic.StoreInt32(&hits, 0)
+		_, err = Get(ctx, s.URL)
+		assert.True(t, tea.IsError(err, context.DeadlineExceeded))
+	})
+
+	t.Run("backs off exponentially", func(t *testing.T) {
+		o := options{backoff: time.Second, maxBackoff: 5 * time.Second}
+		for i := 0; i < 10; i++ {
+			assert.GreaterOrEqual(t, o.delay(0, nil), 500*time.Millisecond)
+			assert.LessOrEqual(t, o.delay(0, nil), time.Second)
+			assert.GreaterOrEqual(t, o.delay(2, nil), 2*time.Second)
+			assert.LessOrEqual(t, o.delay(2, nil), 4*time.Second)
+			assert.LessOrEqual(t, o.delay(62, nil), 5*time.Second)
+		}
+
+		now := time.Date(2021, 11, 15, 0, 0, 0, 0, time.UTC)
+		d, ok := retryAfter("Mon, 15 Nov 2021 00:00:30 GMT", now)
+		assert.True(t, ok)
+		assert.Equal(t, 30*time.Second, d)
+
+		_, ok = retryAfter("soon", now)
+		assert.False(t, ok)
+	})
+
+	t.Run("custom client and headers", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("User-Agent") != "service/1.0" || r.Header.Get("X-Service") != "way" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+
+		var calls int32
+		hc := http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return http.DefaultTransport.RoundTrip(r)
+		})}
+
+		_, err := Get(context.TODO(), s.URL, HTTPClient(&hc), Header("User-Agent", "service/1.0"), Header("X-Service", "way"))
+		assert.Nil(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); !ok || user != "42" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			http.Redirect(w, r, bucket.URL, http.StatusFound)
+		}))
+
+		_, err := Get(context.TODO(), s.URL)
+		assert.NotNil(t, err)
+
+		_, err = Get(context.TODO(), strings.Replace(s.URL, "127.0.0.1", "localhost", 1), BasicAuth("42", "secret"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("redacts secrets from errors", func(t *testing.T) {
+		hc := http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection failed")
+		})}
+
+		_, err := Get(context.TODO(), "https://example.com/data?license_key=secret", HTTPClient(&hc))
+		assert.NotNil(t, err)
+		assert.NotContains(t, err.Error(), "secret")
+		assert.Contains(t, err.Error(), "connection failed")
+		assert.Equal(t, "REDACTED", Redact("://bad"))
+	})
+
 	t.Run("success", func(t *testing.T) {
 		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 		_, err := Get(context.TODO(), s.URL)
 		assert.Nil(t, err)
 	})
 }
+
+// roundTripper is a function implementing http.RoundTripper
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (fn roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
@@ -2,14 +2,17 @@ package geonames
 
 import (
 	"archive/zip"
-	"bytes"
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"io/fs"
+	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pghq/go-ark"
 	"github.com/pghq/go-ark/database"
@@ -17,6 +20,8 @@ import (
 
 	"github.com/pghq/go-way/client"
 	"github.com/pghq/go-way/country"
+	"github.com/pghq/go-way/internal/errs"
+	"github.com/pghq/go-way/source"
 )
 
 const (
@@ -35,81 +40,323 @@ var schema = database.Schema{
 	},
 }
 
+var (
+	// ErrNotReady is returned by lookups on a client that has not been loaded yet
+	ErrNotReady = errs.ErrNotReady
+
+	// ErrNotFound is returned by lookups that match no location
+	ErrNotFound = errs.ErrNotFound
+
+	// ErrInvalidInput is returned by lookups with malformed arguments
+	ErrInvalidInput = errs.ErrInvalidInput
+
+	// ErrClosed is returned by lookups on a closed client
+	ErrClosed = errs.ErrClosed
+)
+
+// Error is a lookup error that can be inspected with errors.Is and errors.As
+type Error = errs.Error
+
 // Client for GeoNames
+// Size is the approximate memory used by the locations in bytes (the database holds the locations, the spatial index only their coordinates and keys)
+// CountryCounts is the number of locations loaded for each country
 type Client struct {
 	LocationCount int
+	CountryCounts map[country.Country]int
+	Validator     client.Validator
+	Size          int64
 	db            *ark.Mapper
+	index         index
+	mu            sync.RWMutex
+	closed        bool
 }
 
 // Get a location
 func (c *Client) Get(id LocationId) (*Location, error) {
-	if c == nil {
-		return nil, tea.ErrNotFound("client not ready")
+	return c.GetContext(context.Background(), id)
+}
+
+// GetContext gets a location, honoring cancellation of the context
+func (c *Client) GetContext(ctx context.Context, id LocationId) (*Location, error) {
+	locations, err := c.GetAllContext(ctx, id)
+	if err != nil {
+		return nil, errs.Trace(err)
 	}
 
-	var fence *Location
-	return fence, c.db.View(context.Background(), func(tx ark.Txn) error {
-		var query interface{}
-		switch {
-		case id.IsCity():
-			query = database.Eq("city", id.country, id.primary, id.city)
-		case id.IsPostal():
-			query = database.Eq("postal", id.country, id.postalCode)
-		case id.IsPrimary():
-			query = database.Eq("subdivision1", id.country, id.primary)
-		case id.IsSecondary():
-			query = database.Eq("subdivision2", id.country, id.primary, id.secondary)
-		case id.IsCountry():
-			query = database.Eq("country", id.country)
-		default:
-			return tea.Err("bad id")
-		}
+	return locations[0], nil
+}
 
-		var locations []Location
-		if err := tx.List("locations", &locations, query, database.Limit(-1)); err != nil {
-			return tea.Stacktrace(err)
-		}
+// GetAll gets several locations within a single transaction
+// duplicate ids are only looked up once
+func (c *Client) GetAll(ids ...LocationId) ([]*Location, error) {
+	return c.GetAllContext(context.Background(), ids...)
+}
 
-		var location *Location
-		for _, loc := range locations {
-			l := loc
-			if location == nil {
-				location = &l
-			} else {
-				location.Add(&l)
+// GetAllContext gets several locations within a single transaction, honoring cancellation of the context
+func (c *Client) GetAllContext(ctx context.Context, ids ...LocationId) ([]*Location, error) {
+	span := tea.Nest(ctx, "geonames")
+	defer span.End()
+
+	span.Tag("lookup", "get")
+	release, err := c.acquire()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+	defer release()
+
+	fences := make([]*Location, len(ids))
+	err = c.db.View(span, func(tx ark.Txn) error {
+		resolved := make(map[LocationId]*Location, len(ids))
+		for i, id := range ids {
+			if fence, present := resolved[id]; present {
+				fences[i] = fence
+				continue
+			}
+
+			// context errors are traced by the transaction (tracing twice hides the cause)
+			if err := span.Err(); err != nil {
+				return err
+			}
+
+			fence, err := get(tx, id)
+			if err != nil {
+				return tea.Stacktrace(err)
 			}
+
+			resolved[id] = fence
+			fences[i] = fence
 		}
 
-		fence = location
 		return nil
-	})
+	}, database.BatchReadSize(len(ids)))
+
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return fences, nil
+}
+
+// Reverse finds the closest postal code location to a coordinate
+func (c *Client) Reverse(latitude, longitude float64) (*Location, error) {
+	return c.ReverseContext(context.Background(), latitude, longitude)
+}
+
+// ReverseContext finds the closest postal code location to a coordinate, honoring cancellation of the context
+func (c *Client) ReverseContext(ctx context.Context, latitude, longitude float64) (*Location, error) {
+	span := tea.Nest(ctx, "geonames")
+	defer span.End()
+
+	span.Tag("lookup", "reverse")
+	release, err := c.acquire()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+	defer release()
+
+	center := Coordinate{Latitude: latitude, Longitude: longitude}
+	if !center.valid() {
+		return nil, errs.New(ErrInvalidInput, "bad coordinate")
+	}
+
+	neighbors, err := c.nearest(span, center, 1, filter{})
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	if len(neighbors) == 0 {
+		return nil, errs.New(ErrNotFound, "no location near coordinate")
+	}
+
+	return &neighbors[0].Location, nil
+}
+
+// Within finds all postal code locations within a radius (in km) of a coordinate, closest first
+func (c *Client) Within(center Coordinate, radiusKm float64, opts ...SearchOption) ([]Neighbor, error) {
+	return c.WithinContext(context.Background(), center, radiusKm, opts...)
+}
+
+// WithinContext finds all postal code locations within a radius (in km) of a coordinate, honoring cancellation of the context
+func (c *Client) WithinContext(ctx context.Context, center Coordinate, radiusKm float64, opts ...SearchOption) ([]Neighbor, error) {
+	span := tea.Nest(ctx, "geonames")
+	defer span.End()
+
+	span.Tag("lookup", "within")
+	release, err := c.acquire()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+	defer release()
+
+	if !center.valid() {
+		return nil, errs.New(ErrInvalidInput, "bad coordinate")
+	}
+
+	if !(radiusKm >= 0) {
+		return nil, errs.New(ErrInvalidInput, "bad radius")
+	}
+
+	neighbors, err := c.within(span, center, radiusKm, newFilter(opts), make(map[string]*Location))
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return neighbors, nil
+}
+
+// Nearest finds the k closest postal code locations to a coordinate, closest first
+func (c *Client) Nearest(center Coordinate, k int, opts ...SearchOption) ([]Neighbor, error) {
+	return c.NearestContext(context.Background(), center, k, opts...)
+}
+
+// NearestContext finds the k closest postal code locations to a coordinate, honoring cancellation of the context
+func (c *Client) NearestContext(ctx context.Context, center Coordinate, k int, opts ...SearchOption) ([]Neighbor, error) {
+	span := tea.Nest(ctx, "geonames")
+	defer span.End()
+
+	span.Tag("lookup", "nearest")
+	release, err := c.acquire()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+	defer release()
+
+	if !center.valid() {
+		return nil, errs.New(ErrInvalidInput, "bad coordinate")
+	}
+
+	if k <= 0 {
+		return nil, errs.New(ErrInvalidInput, "k must be positive")
+	}
+
+	neighbors, err := c.nearest(span, center, k, newFilter(opts))
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return neighbors, nil
+}
+
+// Close the client and release its locations
+// waits for in-flight lookups to finish
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.db = nil
+	c.index = index{}
+	return nil
+}
+
+// acquire the client for reading; release must be called once done
+func (c *Client) acquire() (release func(), err error) {
+	if c == nil {
+		return nil, errs.New(ErrNotReady, "client not ready")
+	}
+
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return nil, errs.New(ErrClosed, "client closed")
+	}
+
+	return c.mu.RUnlock, nil
+}
+
+// get a location within a transaction
+func get(tx ark.Txn, id LocationId) (*Location, error) {
+	var query interface{}
+	switch {
+	case id.IsCity():
+		query = database.Eq("city", id.country, id.primary, id.city)
+	case id.IsPostal():
+		query = database.Eq("postal", id.country, id.postalCode)
+	case id.IsPrimary():
+		query = database.Eq("subdivision1", id.country, id.primary)
+	case id.IsSecondary():
+		query = database.Eq("subdivision2", id.country, id.primary, id.secondary)
+	case id.IsCountry():
+		query = database.Eq("country", id.country)
+	default:
+		return nil, errs.New(ErrInvalidInput, "bad id")
+	}
+
+	var locations []Location
+	if err := tx.List("locations", &locations, query, database.Limit(-1)); err != nil {
+		if tea.ErrStatus(err) == http.StatusNoContent {
+			return nil, errs.New(ErrNotFound, "location not found")
+		}
+
+		return nil, tea.Stacktrace(err)
+	}
+
+	if len(locations) == 0 {
+		return nil, errs.New(ErrNotFound, "location not found")
+	}
+
+	var location *Location
+	for _, loc := range locations {
+		l := loc
+		if location == nil {
+			location = &l
+		} else {
+			location.Add(&l)
+		}
+	}
+
+	return location, nil
+}
+
+// Refresh creates a new client if the export has changed since this client was created
+// returns client.ErrNotModified otherwise
+func (c *Client) Refresh(ctx context.Context, src source.Source, countries ...string) (*Client, error) {
+	var since client.Validator
+	if c != nil {
+		since = c.Validator
+	}
+
+	return newClient(ctx, src, since, countries...)
 }
 
 // NewClient Creates a new GeoNames client
 func NewClient(ctx context.Context, uri string, countries ...string) (*Client, error) {
-	resp, err := client.Get(ctx, uri)
+	return Open(ctx, source.URL(uri), countries...)
+}
+
+// Open creates a new GeoNames client from a source
+func Open(ctx context.Context, src source.Source, countries ...string) (*Client, error) {
+	return newClient(ctx, src, client.Validator{}, countries...)
+}
+
+// newClient creates a new GeoNames client if the export has changed since the validator
+func newClient(ctx context.Context, src source.Source, since client.Validator, countries ...string) (*Client, error) {
+	body, validator, err := src.Open(ctx, since)
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, errs.AtStage(errs.StageDownload, err)
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
 	countryCodes := make(map[string]struct{}, len(countries))
 	for _, countryCode := range countries {
 		countryCodes[strings.ToUpper(countryCode)] = struct{}{}
 	}
 
-	b, _ := ioutil.ReadAll(resp.Body)
-	reader := bytes.NewReader(b)
-	zr, err := zip.NewReader(reader, int64(len(b)))
+	reader, size, release, err := readerAt(body)
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, errs.AtStage(errs.StageDownload, err)
+	}
+	defer release()
+
+	zr, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, errs.AtStage(errs.StageDecompress, err)
 	}
 
 	if len(zr.File) != 1 {
-		return nil, tea.Errf("unexpected number of files in zip, %d found", len(zr.File))
+		return nil, errs.AtStage(errs.StageDecompress, tea.Errf("unexpected number of files in zip, %d found", len(zr.File)))
 	}
 
-	c := Client{}
+	c := Client{Validator: validator, CountryCounts: make(map[country.Country]int)}
 	c.db = ark.New("memory://", database.Storage(schema))
 	err = c.db.Do(ctx, func(tx ark.Txn) error {
 		var f io.ReadCloser
@@ -131,7 +378,7 @@ func NewClient(ctx context.Context, uri string, countries ...string) (*Client, e
 				}
 
 				if len(record) != numColumns {
-					return tea.Errf("unexpected number of columns in csv, %d found", len(record))
+					return errs.AtStage(errs.StageParse, tea.Errf("unexpected number of columns in csv, %d found", len(record)))
 				}
 
 				countryCode := strings.ToUpper(record[0])
@@ -144,12 +391,12 @@ func NewClient(ctx context.Context, uri string, countries ...string) (*Client, e
 
 				latitude, err := strconv.ParseFloat(record[9], 64)
 				if err != nil {
-					return tea.Stacktrace(err)
+					return errs.AtStage(errs.StageParse, err)
 				}
 
 				longitude, err := strconv.ParseFloat(record[10], 64)
 				if err != nil {
-					return tea.Stacktrace(err)
+					return errs.AtStage(errs.StageParse, err)
 				}
 
 				postalCode := strings.ToLower(record[1])
@@ -168,16 +415,61 @@ func NewClient(ctx context.Context, uri string, countries ...string) (*Client, e
 
 				if err = tx.Insert("locations", key, location); err == nil {
 					c.LocationCount += 1
+					c.CountryCounts[cty] += 1
+					c.Size += location.size()
+					c.index.add(key, location.Coordinate)
 				}
 			}
 		}
 
-		if err != nil && err != io.EOF {
-			return tea.Stacktrace(err)
+		var perr *csv.ParseError
+		switch {
+		case err == nil, err == io.EOF:
+		case errors.As(err, &perr):
+			return errs.AtStage(errs.StageParse, err)
+		default:
+			return errs.AtStage(errs.StageDecompress, err)
 		}
 
 		return nil
 	}, database.BatchWrite())
 
-	return &c, err
+	if err != nil {
+		return nil, errs.AtStage(errs.StageIndex, err)
+	}
+
+	c.index.build()
+	c.Size += c.index.size()
+	return &c, nil
+}
+
+// readerAt provides random access to an archive
+// archives that cannot be read at random (e.g., http bodies) are spooled to a temporary file rather than memory
+func readerAt(body io.Reader) (io.ReaderAt, int64, func(), error) {
+	if f, ok := body.(interface {
+		io.ReaderAt
+		Stat() (fs.FileInfo, error)
+	}); ok {
+		if info, err := f.Stat(); err == nil {
+			return f, info.Size(), func() {}, nil
+		}
+	}
+
+	spool, err := os.CreateTemp("", "geonames-*.zip")
+	if err != nil {
+		return nil, 0, nil, tea.Stacktrace(err)
+	}
+
+	release := func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}
+
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		release()
+		return nil, 0, nil, tea.Stacktrace(err)
+	}
+
+	return spool, size, release, nil
 }
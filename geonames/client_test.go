@@ -2,11 +2,21 @@ package geonames
 
 import (
 	"context"
+	"errors"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"sync"
 	"testing"
 
+	"github.com/pghq/go-tea"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/country"
+	"github.com/pghq/go-way/internal/errs"
+	"github.com/pghq/go-way/source"
 )
 
 func TestDB_Get(t *testing.T) {
@@ -15,7 +25,7 @@ func TestDB_Get(t *testing.T) {
 	t.Run("not ready", func(t *testing.T) {
 		var c *Client
 		_, err := c.Get(LocationId{})
-		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, ErrNotReady))
 	})
 
 	t.Run("bad location", func(t *testing.T) {
@@ -35,13 +45,13 @@ func TestDB_Get(t *testing.T) {
 	t.Run("too many files", func(t *testing.T) {
 		s := serve("../testdata/too-many-files.zip")
 		_, err := NewClient(context.TODO(), s.URL)
-		assert.NotNil(t, err)
+		assert.Equal(t, errs.StageDecompress, errs.StageOf(err))
 	})
 
 	t.Run("bad columns", func(t *testing.T) {
 		s := serve("../testdata/bad-columns.zip")
 		_, err := NewClient(context.TODO(), s.URL)
-		assert.NotNil(t, err)
+		assert.Equal(t, errs.StageParse, errs.StageOf(err))
 	})
 
 	t.Run("bad latitude", func(t *testing.T) {
@@ -71,15 +81,38 @@ func TestDB_Get(t *testing.T) {
 	s := serve("../testdata/sample.zip")
 	c, _ := NewClient(context.TODO(), s.URL)
 
+	t.Run("not modified", func(t *testing.T) {
+		assert.False(t, c.Validator.IsZero())
+		_, err := c.Refresh(context.TODO(), source.URL(s.URL))
+		assert.NotNil(t, err)
+		assert.True(t, tea.IsError(err, client.ErrNotModified))
+	})
+
+	t.Run("refresh without client", func(t *testing.T) {
+		var c *Client
+		c, err := c.Refresh(context.TODO(), source.URL(s.URL))
+		assert.Nil(t, err)
+		assert.NotNil(t, c)
+	})
+
+	t.Run("from file system", func(t *testing.T) {
+		c, err := Open(context.TODO(), source.FS(os.DirFS("../testdata"), "sample.zip"))
+		assert.Nil(t, err)
+		assert.Equal(t, 2898, c.LocationCount)
+		assert.Positive(t, c.Size)
+	})
+
 	t.Run("with countries", func(t *testing.T) {
-		_, err := NewClient(context.TODO(), s.URL, "us")
+		c, err := NewClient(context.TODO(), s.URL, "us")
 		assert.Nil(t, err)
+		assert.Equal(t, c.LocationCount, c.CountryCounts["US"])
+		assert.Len(t, c.CountryCounts, 1)
 	})
 
 	t.Run("should notify on errors", func(t *testing.T) {
 		t.Run("bad location", func(t *testing.T) {
 			_, err := c.Get(LocationId{})
-			assert.NotNil(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidInput))
 
 			loc := Location{}
 			assert.Equal(t, 0.0, loc.Radius())
@@ -89,12 +122,33 @@ func TestDB_Get(t *testing.T) {
 
 		t.Run("not found postal", func(t *testing.T) {
 			_, err := c.Get(PostalCode("US", "999999"))
-			assert.NotNil(t, err)
+			assert.True(t, errors.Is(err, ErrNotFound))
+
+			var e *Error
+			assert.True(t, errors.As(err, &e))
+			assert.Equal(t, ErrNotFound, e.Kind)
 		})
 
 		t.Run("not found country", func(t *testing.T) {
 			_, err := c.Get(Country("USA"))
-			assert.NotNil(t, err)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+
+		t.Run("canceled context", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+
+			_, err := c.GetContext(ctx, PostalCode("US", "20017"))
+			assert.True(t, errors.Is(err, context.Canceled))
+
+			_, err = c.ReverseContext(ctx, 38.9367, -76.994)
+			assert.True(t, errors.Is(err, context.Canceled))
+
+			_, err = c.WithinContext(ctx, Coordinate{Latitude: 38.9367, Longitude: -76.994}, 5)
+			assert.True(t, errors.Is(err, context.Canceled))
+
+			_, err = c.GetAllContext(&expiring{Context: context.TODO(), after: 1}, PostalCode("US", "20017"), PostalCode("US", "20018"))
+			assert.True(t, errors.Is(err, context.Canceled))
 		})
 	})
 
@@ -132,6 +186,324 @@ func TestDB_Get(t *testing.T) {
 	})
 }
 
+func TestClient_Reverse(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not ready", func(t *testing.T) {
+		var c *Client
+		_, err := c.Reverse(38.9367, -76.994)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		s := serve("../testdata/sample.zip")
+		c, _ := NewClient(context.TODO(), s.URL, "ZZ")
+		_, err := c.Reverse(38.9367, -76.994)
+		assert.NotNil(t, err)
+	})
+
+	s := serve("../testdata/sample.zip")
+	c, _ := NewClient(context.TODO(), s.URL)
+
+	t.Run("bad coordinate", func(t *testing.T) {
+		_, err := c.Reverse(500, 900)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+
+		_, err = c.Reverse(math.NaN(), 0)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("exact", func(t *testing.T) {
+		loc, err := c.Reverse(38.9367, -76.994)
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
+		assert.Equal(t, "20017", loc.PostalCode)
+	})
+
+	t.Run("nearby", func(t *testing.T) {
+		loc, err := c.Reverse(38.9280, -76.9765)
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
+		assert.Equal(t, "20018", loc.PostalCode)
+	})
+
+	t.Run("far away", func(t *testing.T) {
+		loc, err := c.Reverse(-77.8419, 166.6863)
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
+	})
+}
+
+func TestClient_Within(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not ready", func(t *testing.T) {
+		var c *Client
+		_, err := c.Within(Coordinate{Latitude: 38.9367, Longitude: -76.994}, 5)
+		assert.NotNil(t, err)
+	})
+
+	s := serve("../testdata/sample.zip")
+	c, _ := NewClient(context.TODO(), s.URL)
+
+	t.Run("bad center", func(t *testing.T) {
+		_, err := c.Within(Coordinate{Latitude: 38.9367, Longitude: 900}, 5)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+
+		_, err = c.Within(Coordinate{Latitude: math.NaN(), Longitude: -76.994}, 5)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("negative radius", func(t *testing.T) {
+		_, err := c.Within(Coordinate{Latitude: 38.9367, Longitude: -76.994}, -1)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("NaN radius", func(t *testing.T) {
+		_, err := c.Within(Coordinate{Latitude: 38.9367, Longitude: -76.994}, math.NaN())
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("nothing nearby", func(t *testing.T) {
+		neighbors, err := c.Within(Coordinate{Latitude: -77.8419, Longitude: 166.6863}, 100)
+		assert.Nil(t, err)
+		assert.Empty(t, neighbors)
+	})
+
+	t.Run("sorted by distance", func(t *testing.T) {
+		neighbors, err := c.Within(Coordinate{Latitude: 38.9367, Longitude: -76.994}, 5)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, neighbors)
+		assert.Equal(t, "20017", neighbors[0].PostalCode)
+		assert.Equal(t, 0.0, neighbors[0].Distance)
+		for i, neighbor := range neighbors {
+			assert.LessOrEqual(t, neighbor.Distance, 5.0)
+			if i > 0 {
+				assert.LessOrEqual(t, neighbors[i-1].Distance, neighbor.Distance)
+			}
+		}
+	})
+
+	t.Run("whole earth", func(t *testing.T) {
+		neighbors, err := c.Within(Coordinate{Latitude: 38.9367, Longitude: -76.994}, 25000)
+		assert.Nil(t, err)
+		assert.Len(t, neighbors, c.index.Len())
+	})
+
+	t.Run("index holds no locations", func(t *testing.T) {
+		assert.Less(t, c.index.size()*2, c.Size)
+	})
+}
+
+func TestClient_Nearest(t *testing.T) {
+	t.Parallel()
+
+	center := Coordinate{Latitude: 38.9367, Longitude: -76.994}
+
+	t.Run("not ready", func(t *testing.T) {
+		var c *Client
+		_, err := c.Nearest(center, 1)
+		assert.NotNil(t, err)
+	})
+
+	s := serve("../testdata/sample.zip")
+	c, _ := NewClient(context.TODO(), s.URL)
+
+	t.Run("bad center", func(t *testing.T) {
+		_, err := c.Nearest(Coordinate{Latitude: -91, Longitude: -76.994}, 1)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+
+		_, err = c.Nearest(Coordinate{Latitude: 38.9367, Longitude: math.NaN()}, 1)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("bad k", func(t *testing.T) {
+		_, err := c.Nearest(center, 0)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("closest first", func(t *testing.T) {
+		neighbors, err := c.Nearest(center, 3)
+		assert.Nil(t, err)
+		assert.Len(t, neighbors, 3)
+		assert.Equal(t, "20017", neighbors[0].PostalCode)
+		assert.Equal(t, center, neighbors[0].Coordinate)
+		assert.LessOrEqual(t, neighbors[0].Distance, neighbors[1].Distance)
+		assert.LessOrEqual(t, neighbors[1].Distance, neighbors[2].Distance)
+	})
+
+	t.Run("in country", func(t *testing.T) {
+		neighbors, err := c.Nearest(center, 2, InCountry("CA"))
+		assert.Nil(t, err)
+		assert.Len(t, neighbors, 2)
+		for _, neighbor := range neighbors {
+			assert.Equal(t, country.Canada, neighbor.Country)
+		}
+	})
+
+	t.Run("in primary", func(t *testing.T) {
+		neighbors, err := c.Nearest(center, 2, InPrimary("US", "NY"))
+		assert.Nil(t, err)
+		assert.Len(t, neighbors, 2)
+		for _, neighbor := range neighbors {
+			assert.Equal(t, "ny", neighbor.Subdivision1)
+		}
+	})
+
+	t.Run("fewer than k", func(t *testing.T) {
+		neighbors, err := c.Nearest(center, 10, InCountry("GT"))
+		assert.Nil(t, err)
+		assert.Len(t, neighbors, 1)
+	})
+
+	t.Run("within country", func(t *testing.T) {
+		neighbors, err := c.Within(center, 5, InCountry("CA"))
+		assert.Nil(t, err)
+		assert.Empty(t, neighbors)
+	})
+}
+
+func TestMeasure(t *testing.T) {
+	t.Parallel()
+
+	flindersPeak := Coordinate{Latitude: -37.951033416666666, Longitude: 144.42486788888888}
+	buninyong := Coordinate{Latitude: -37.65282114, Longitude: 143.92649553}
+
+	t.Run("same point", func(t *testing.T) {
+		d := Measure(flindersPeak, flindersPeak)
+		assert.Equal(t, 0.0, d.Kilometers)
+
+		d = Measure(flindersPeak, flindersPeak, Ellipsoidal())
+		assert.Equal(t, 0.0, d.Kilometers)
+	})
+
+	t.Run("haversine", func(t *testing.T) {
+		d := Measure(flindersPeak, buninyong)
+		assert.InDelta(t, 54.97, d.Kilometers, 0.2)
+		assert.InDelta(t, d.Kilometers/1.609344, d.Miles, 1e-9)
+		assert.InDelta(t, 306.98, d.Bearing, 0.01)
+	})
+
+	t.Run("vincenty", func(t *testing.T) {
+		d := Measure(flindersPeak, buninyong, Ellipsoidal())
+		assert.InDelta(t, 54.972271, d.Kilometers, 1e-6)
+		assert.InDelta(t, 306.868158, d.Bearing, 1e-5)
+	})
+
+	t.Run("antipodal", func(t *testing.T) {
+		d := Measure(Coordinate{}, Coordinate{Latitude: 0.5, Longitude: 179.7}, Ellipsoidal())
+		assert.InDelta(t, Measure(Coordinate{}, Coordinate{Latitude: 0.5, Longitude: 179.7}).Kilometers, d.Kilometers, 1e-9)
+	})
+}
+
+func TestClient_GetAll(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not ready", func(t *testing.T) {
+		var c *Client
+		_, err := c.GetAll(PostalCode("US", "20017"))
+		assert.NotNil(t, err)
+	})
+
+	s := serve("../testdata/sample.zip")
+	c, _ := NewClient(context.TODO(), s.URL)
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := c.GetAll(PostalCode("US", "20017"), PostalCode("US", "999999"))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("can retrieve locations", func(t *testing.T) {
+		locations, err := c.GetAll(PostalCode("US", "20017"), City("US", "ny", "brooklyn"), PostalCode("US", "20017"))
+		assert.Nil(t, err)
+		assert.Len(t, locations, 3)
+		assert.Equal(t, "20017", locations[0].PostalCode)
+		assert.Equal(t, "brooklyn", locations[1].City)
+		assert.Same(t, locations[0], locations[2])
+	})
+}
+
+func TestMatrix(t *testing.T) {
+	t.Parallel()
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, Matrix(nil, []Coordinate{{}}))
+	})
+
+	t.Run("can measure", func(t *testing.T) {
+		origins := []Coordinate{{Latitude: 38.9367, Longitude: -76.994}, {Latitude: 40.694, Longitude: -73.9903}}
+		destinations := []Coordinate{{Latitude: 40.694, Longitude: -73.9903}, {Latitude: 38.9367, Longitude: -76.994}, {}}
+		matrix := Matrix(origins, destinations, Ellipsoidal())
+		assert.Len(t, matrix, 2)
+		for i, row := range matrix {
+			assert.Len(t, row, 3)
+			for j, d := range row {
+				assert.Equal(t, Measure(origins[i], destinations[j], Ellipsoidal()), d)
+			}
+		}
+		assert.Equal(t, 0.0, matrix[0][1].Kilometers)
+		assert.Equal(t, 0.0, matrix[1][0].Kilometers)
+	})
+}
+
+func TestClient_Close(t *testing.T) {
+	t.Parallel()
+
+	s := serve("../testdata/sample.zip")
+	c, _ := NewClient(context.TODO(), s.URL)
+	assert.Nil(t, c.Close())
+	assert.Nil(t, c.Close())
+
+	_, err := c.Get(PostalCode("US", "20017"))
+	assert.NotNil(t, err)
+
+	_, err = c.Nearest(Coordinate{}, 1)
+	assert.NotNil(t, err)
+}
+
+// expiring is a context that is canceled after a number of checks
+type expiring struct {
+	context.Context
+	mu    sync.Mutex
+	after int
+}
+
+func (e *expiring) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.after--; e.after < 0 {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+func TestClient_TempFiles(t *testing.T) {
+	// not parallel, the temp dir is set for the whole process
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	t.Run("removed on error", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("bad body"))
+		}))
+
+		_, err := NewClient(context.TODO(), s.URL)
+		assert.NotNil(t, err)
+		files, _ := os.ReadDir(dir)
+		assert.Empty(t, files)
+	})
+
+	t.Run("removed on success", func(t *testing.T) {
+		s := serve("../testdata/sample.zip")
+		c, err := NewClient(context.TODO(), s.URL)
+		assert.Nil(t, err)
+		assert.NotNil(t, c)
+		files, _ := os.ReadDir(dir)
+		assert.Empty(t, files)
+	})
+}
+
 func serve(path string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, path)
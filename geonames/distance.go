@@ -0,0 +1,174 @@
+package geonames
+
+import (
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// kmPerMile is the number of kilometers in a statute mile
+	kmPerMile = 1.609344
+
+	// wgs84A is the semi-major axis of the WGS84 ellipsoid in kilometers
+	wgs84A = 6378.137
+
+	// wgs84F is the flattening of the WGS84 ellipsoid
+	wgs84F = 1 / 298.257223563
+
+	// wgs84B is the semi-minor axis of the WGS84 ellipsoid in kilometers
+	wgs84B = (1 - wgs84F) * wgs84A
+
+	// vincentyIterations is the maximum number of iterations before falling back to haversine
+	vincentyIterations = 200
+
+	// vincentyTolerance is the convergence threshold for the longitude difference on the auxiliary sphere
+	vincentyTolerance = 1e-12
+)
+
+// Distance between two coordinates
+type Distance struct {
+	// Kilometers is the distance in kilometers
+	Kilometers float64
+
+	// Miles is the distance in statute miles
+	Miles float64
+
+	// Bearing is the initial bearing in degrees clockwise from true north
+	Bearing float64
+}
+
+// DistanceOption to configure how distances are measured
+type DistanceOption func(m *measure)
+
+// Ellipsoidal measures distances on the WGS84 ellipsoid (Vincenty) instead of a sphere (haversine)
+func Ellipsoidal() DistanceOption {
+	return func(m *measure) {
+		m.ellipsoidal = true
+	}
+}
+
+// measure configuration
+type measure struct {
+	ellipsoidal bool
+}
+
+// Measure the distance and initial bearing from a to b
+func Measure(a, b Coordinate, opts ...DistanceOption) Distance {
+	var m measure
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	if m.ellipsoidal {
+		if d, ok := vincenty(a, b); ok {
+			return d
+		}
+	}
+
+	return haversine(a, b)
+}
+
+// Matrix measures the distance from every origin to every destination
+// rows are computed in parallel; matrix[i][j] is the distance from origins[i] to destinations[j]
+func Matrix(origins, destinations []Coordinate, opts ...DistanceOption) [][]Distance {
+	matrix := make([][]Distance, len(origins))
+	rows := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < runtime.GOMAXPROCS(0) && w < len(origins); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rows {
+				row := make([]Distance, len(destinations))
+				for j, destination := range destinations {
+					row[j] = Measure(origins[i], destination, opts...)
+				}
+				matrix[i] = row
+			}
+		}()
+	}
+
+	for i := range origins {
+		rows <- i
+	}
+
+	close(rows)
+	wg.Wait()
+	return matrix
+}
+
+// haversine measures the great-circle distance on a sphere
+func haversine(a, b Coordinate) Distance {
+	km := distance(s2.LatLngFromDegrees(a.Latitude, a.Longitude), b)
+	phi1, phi2 := radians(a.Latitude), radians(b.Latitude)
+	dLambda := radians(b.Longitude - a.Longitude)
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return newDistance(km, math.Atan2(y, x))
+}
+
+// vincenty measures the geodesic distance on the WGS84 ellipsoid
+// returns false if the formula does not converge (e.g., nearly antipodal points)
+func vincenty(a, b Coordinate) (Distance, bool) {
+	l := radians(b.Longitude - a.Longitude)
+	u1 := math.Atan((1 - wgs84F) * math.Tan(radians(a.Latitude)))
+	u2 := math.Atan((1 - wgs84F) * math.Tan(radians(b.Latitude)))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+	var sinLambda, cosLambda, sinSigma, cosSigma, sigma, cos2Alpha, cos2SigmaM float64
+	for i := 0; ; i++ {
+		if i == vincentyIterations {
+			return Distance{}, false
+		}
+
+		sinLambda, cosLambda = math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return Distance{}, true
+		}
+
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+
+		c := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = l + (1-c)*wgs84F*sinAlpha*(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < vincentyTolerance {
+			break
+		}
+	}
+
+	uSq := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	km := wgs84B * A * (sigma - deltaSigma)
+	return newDistance(km, math.Atan2(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)), true
+}
+
+// newDistance creates a distance from kilometers and a bearing in radians
+func newDistance(km, bearing float64) Distance {
+	return Distance{
+		Kilometers: km,
+		Miles:      km / kmPerMile,
+		Bearing:    math.Mod(bearing*180/math.Pi+360, 360),
+	}
+}
+
+// radians converts degrees to radians
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
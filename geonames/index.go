@@ -0,0 +1,279 @@
+package geonames
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/pghq/go-ark"
+	"github.com/pghq/go-ark/database"
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/country"
+)
+
+const (
+	// initialSearchRadiusKm is the radius of the first ring searched for nearby locations
+	initialSearchRadiusKm = 10
+
+	// maxCoveringCells is the maximum number of cells used to cover a search region
+	maxCoveringCells = 16
+
+	// maxCellLevel is the deepest S2 cell level (leaf cells)
+	maxCellLevel = 30
+
+	// maxSearchRadiusKm is the largest possible search radius (half the Earth's circumference)
+	maxSearchRadiusKm = math.Pi * earthRadiusKm
+)
+
+// Neighbor is a location near a point of interest
+type Neighbor struct {
+	Location
+
+	// Distance to the point of interest in kilometers
+	Distance float64
+}
+
+// SearchOption to narrow down searches for nearby locations
+type SearchOption func(f *filter)
+
+// InCountry limits a search to locations in a country
+func InCountry(country country.Country) SearchOption {
+	return func(f *filter) {
+		f.country = country
+	}
+}
+
+// InPrimary limits a search to locations in a first order subdivision
+func InPrimary(country country.Country, subdivision1 string) SearchOption {
+	return func(f *filter) {
+		f.country = country
+		f.subdivision1 = strings.ToLower(subdivision1)
+	}
+}
+
+// filter for nearby locations
+type filter struct {
+	country      country.Country
+	subdivision1 string
+}
+
+// matches checks if the location passes the filter
+func (f filter) matches(location *Location) bool {
+	if f.country != "" && f.country != location.Country {
+		return false
+	}
+
+	return f.subdivision1 == "" || f.subdivision1 == location.Subdivision1
+}
+
+// newFilter creates a filter from search options
+func newFilter(opts []SearchOption) filter {
+	var f filter
+	for _, opt := range opts {
+		opt(&f)
+	}
+
+	return f
+}
+
+// entry is an indexed location, which is resolved from the database by its key
+type entry struct {
+	cell       s2.CellID
+	coordinate Coordinate
+	key        string
+}
+
+// candidate is an indexed location within a search radius, before it is resolved from the database
+type candidate struct {
+	key      string
+	distance float64
+}
+
+// id of the candidate, parsed from its database key (country.postal code)
+func (c candidate) id() LocationId {
+	i := strings.IndexByte(c.key, '.')
+	return PostalCode(country.Country(c.key[:i]), c.key[i+1:])
+}
+
+// index is a spatial index of postal code locations ordered by S2 cell
+// it only keeps the coordinate and database key of each location, so that the locations are not held in memory twice
+type index struct {
+	entries   []entry
+	positions map[string]int
+}
+
+// add a location to the index, replacing any previous location with the same key
+func (i *index) add(key string, coordinate Coordinate) {
+	if i.positions == nil {
+		i.positions = make(map[string]int)
+	}
+
+	e := entry{
+		cell:       s2.CellIDFromLatLng(s2.LatLngFromDegrees(coordinate.Latitude, coordinate.Longitude)),
+		coordinate: coordinate,
+		key:        key,
+	}
+
+	if pos, present := i.positions[key]; present {
+		i.entries[pos] = e
+		return
+	}
+
+	i.positions[key] = len(i.entries)
+	i.entries = append(i.entries, e)
+}
+
+// build sorts the index by cell so that it can be searched
+func (i *index) build() {
+	i.positions = nil
+	sort.Sort(i)
+}
+
+// within finds the keys of all locations (in the country, if any) within the radius (in km) of the center, closest first
+func (i *index) within(ctx context.Context, center Coordinate, radiusKm float64, cty country.Country) ([]candidate, error) {
+	if radiusKm < 0 || len(i.entries) == 0 {
+		return nil, nil
+	}
+
+	prefix := ""
+	if cty != "" {
+		prefix = string(cty) + "."
+	}
+
+	origin := s2.LatLngFromDegrees(center.Latitude, center.Longitude)
+	region := s2.CapFromCenterAngle(s2.PointFromLatLng(origin), s1.Angle(radiusKm/earthRadiusKm))
+	coverer := s2.RegionCoverer{MaxLevel: maxCellLevel, MaxCells: maxCoveringCells}
+
+	var candidates []candidate
+	for _, cell := range coverer.Covering(region) {
+		if err := ctx.Err(); err != nil {
+			return nil, tea.Stacktrace(err)
+		}
+
+		start := sort.Search(len(i.entries), func(n int) bool { return i.entries[n].cell >= cell.RangeMin() })
+		for n := start; n < len(i.entries) && i.entries[n].cell <= cell.RangeMax(); n++ {
+			e := &i.entries[n]
+			if !strings.HasPrefix(e.key, prefix) {
+				continue
+			}
+
+			if d := distance(origin, e.coordinate); d <= radiusKm {
+				candidates = append(candidates, candidate{key: e.key, distance: d})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].distance < candidates[b].distance })
+	return candidates, nil
+}
+
+// size is the approximate memory used by the index in bytes
+func (i *index) size() int64 {
+	n := int64(len(i.entries)) * int64(unsafe.Sizeof(entry{}))
+	for _, e := range i.entries {
+		n += int64(len(e.key))
+	}
+
+	return n
+}
+
+// Len is the number of indexed locations
+func (i *index) Len() int {
+	return len(i.entries)
+}
+
+// Less checks if the location at a is ordered before the location at b
+func (i *index) Less(a, b int) bool {
+	return i.entries[a].cell < i.entries[b].cell
+}
+
+// Swap the locations at a and b
+func (i *index) Swap(a, b int) {
+	i.entries[a], i.entries[b] = i.entries[b], i.entries[a]
+}
+
+// within finds all locations passing the filter within the radius (in km) of the center, closest first
+// locations already in resolved are not read from the database again
+func (c *Client) within(ctx context.Context, center Coordinate, radiusKm float64, f filter, resolved map[string]*Location) ([]Neighbor, error) {
+	candidates, err := c.index.within(ctx, center, radiusKm, f.country)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing int
+	for _, cand := range candidates {
+		if _, present := resolved[cand.key]; !present {
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		err = c.db.View(ctx, func(tx ark.Txn) error {
+			for _, cand := range candidates {
+				if _, present := resolved[cand.key]; present {
+					continue
+				}
+
+				// context errors are traced by the transaction (tracing twice hides the cause)
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				location, err := get(tx, cand.id())
+				if err != nil {
+					return tea.Stacktrace(err)
+				}
+
+				resolved[cand.key] = location
+			}
+
+			return nil
+		}, database.BatchReadSize(missing))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	neighbors := make([]Neighbor, 0, len(candidates))
+	for _, cand := range candidates {
+		if location := resolved[cand.key]; f.matches(location) {
+			neighbors = append(neighbors, Neighbor{Location: *location, Distance: cand.distance})
+		}
+	}
+
+	return neighbors, nil
+}
+
+// nearest finds the k closest locations passing the filter to the center
+func (c *Client) nearest(ctx context.Context, center Coordinate, k int, f filter) ([]Neighbor, error) {
+	if k <= 0 || c.index.Len() == 0 {
+		return nil, nil
+	}
+
+	resolved := make(map[string]*Location)
+	for radius := float64(initialSearchRadiusKm); ; radius *= 2 {
+		neighbors, err := c.within(ctx, center, math.Min(radius, maxSearchRadiusKm), f, resolved)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(neighbors) >= k {
+			return neighbors[:k], nil
+		}
+
+		if radius >= maxSearchRadiusKm {
+			return neighbors, nil
+		}
+	}
+}
+
+// distance is the great-circle distance in km between an origin and a coordinate
+func distance(origin s2.LatLng, c Coordinate) float64 {
+	return origin.Distance(s2.LatLngFromDegrees(c.Latitude, c.Longitude)).Radians() * earthRadiusKm
+}
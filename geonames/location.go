@@ -3,6 +3,7 @@ package geonames
 import (
 	"math"
 	"strings"
+	"unsafe"
 
 	"github.com/golang/geo/s2"
 
@@ -23,6 +24,11 @@ type Location struct {
 	Subdivision2 string          `db:"subdivision2"`
 }
 
+// size is the approximate memory used by the location in bytes
+func (l Location) size() int64 {
+	return int64(unsafe.Sizeof(l)) + int64(len(l.Country)+len(l.PostalCode)+len(l.City)+len(l.Subdivision1)+len(l.Subdivision2))
+}
+
 // Add to the envelope
 func (l *Location) Add(loc *Location) {
 	if l.bounder == nil {
@@ -63,6 +69,11 @@ type Coordinate struct {
 	Longitude float64
 }
 
+// valid checks if the coordinate is a point on earth (comparisons are false for NaN)
+func (c Coordinate) valid() bool {
+	return c.Latitude >= -90 && c.Latitude <= 90 && c.Longitude >= -180 && c.Longitude <= 180
+}
+
 // LocationId is the id for the location
 type LocationId struct {
 	country    country.Country
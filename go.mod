@@ -9,6 +9,7 @@ require (
 	github.com/pghq/go-red v0.0.28
 	github.com/pghq/go-tea v0.0.55
 	github.com/stretchr/testify v1.7.0
+	github.com/teambition/rrule-go v1.7.2
 )
 
 require (
@@ -56,7 +57,6 @@ require (
 	github.com/pressly/goose/v3 v3.4.1 // indirect
 	github.com/rs/cors v1.8.0 // indirect
 	github.com/stretchr/objx v0.2.0 // indirect
-	github.com/teambition/rrule-go v1.7.2 // indirect
 	go.opencensus.io v0.22.5 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
@@ -0,0 +1,166 @@
+// Package errs provides the lookup and refresh errors shared by way, geonames and maxmind.
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pghq/go-tea"
+)
+
+var (
+	// ErrNotReady is returned by lookups before the data has been loaded
+	ErrNotReady = errors.New("not ready")
+
+	// ErrNotFound is returned by lookups that match no location
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidInput is returned by lookups with malformed arguments
+	ErrInvalidInput = errors.New("invalid input")
+
+	// ErrClosed is returned by lookups after the data has been released
+	ErrClosed = errors.New("closed")
+
+	// ErrRejected is returned by refreshes of a dataset that failed validation
+	ErrRejected = errors.New("rejected")
+
+	// ErrNoPrevious is returned by rollbacks when there is no previous version to restore
+	ErrNoPrevious = errors.New("no previous version")
+
+	// ErrNotConfigured is returned by lookups of a dataset that has no source
+	ErrNotConfigured = errors.New("not configured")
+)
+
+// Error is a lookup error of a kind (e.g., ErrNotFound or context.Canceled)
+// it matches its kind with errors.Is and keeps the stacktrace of where it occurred
+type Error struct {
+	// Kind of error
+	Kind error
+
+	err   error
+	cause error
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Is checks if the error is of the target kind
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap gets the underlying error (the cause, if any)
+func (e *Error) Unwrap() error {
+	if e.cause != nil {
+		return e.cause
+	}
+
+	return e.err
+}
+
+// Format the error (%+v includes the stacktrace)
+func (e *Error) Format(s fmt.State, verb rune) {
+	if f, ok := e.err.(fmt.Formatter); ok {
+		f.Format(s, verb)
+		return
+	}
+
+	_, _ = fmt.Fprint(s, e.err.Error())
+}
+
+// New creates an error of a kind from a message (the kind itself if empty)
+func New(kind error, v ...interface{}) error {
+	if len(v) == 0 {
+		v = []interface{}{kind}
+	}
+
+	return &Error{Kind: kind, err: tea.Err(v...)}
+}
+
+// Wrap creates an error of a kind from a message and the error that caused it
+// the cause (and anything it wraps) still matches with errors.Is and errors.As
+func Wrap(kind, cause error, v ...interface{}) error {
+	msg := cause.Error()
+	if len(v) > 0 {
+		msg = fmt.Sprint(v...) + ": " + msg
+	}
+
+	return &Error{Kind: kind, err: tea.Err(msg), cause: cause}
+}
+
+// Trace prepares an error for returning from a public lookup
+// lookup and context errors are unwrapped so that they can be inspected with errors.Is and errors.As, all others get a stacktrace
+func Trace(err error) error {
+	var e *Error
+	if tea.AsError(err, &e) {
+		return e
+	}
+
+	for _, kind := range []error{context.Canceled, context.DeadlineExceeded} {
+		if tea.IsError(err, kind) {
+			return &Error{Kind: kind, err: tea.Stacktrace(err)}
+		}
+	}
+
+	return tea.Stacktrace(err)
+}
+
+// Stage of a refresh
+type Stage string
+
+const (
+	// StageDownload is fetching the dataset from its source
+	StageDownload Stage = "download"
+
+	// StageDecompress is extracting the dataset from its archive
+	StageDecompress Stage = "decompress"
+
+	// StageParse is reading the records of the dataset
+	StageParse Stage = "parse"
+
+	// StageIndex is storing and indexing the records of the dataset
+	StageIndex Stage = "index"
+
+	// StageValidate is checking the dataset before it replaces the current one
+	StageValidate Stage = "validate"
+)
+
+// stageError is an error that occurred during a stage of a refresh
+type stageError struct {
+	stage Stage
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stageError) Is(target error) bool {
+	return tea.IsError(e.err, target)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// AtStage records the refresh stage an error occurred in, unless it has already been recorded
+func AtStage(stage Stage, err error) error {
+	if StageOf(err) != "" {
+		return tea.Stacktrace(err)
+	}
+
+	return tea.Stacktrace(&stageError{stage: stage, err: err})
+}
+
+// StageOf gets the refresh stage an error occurred in (empty if unknown)
+func StageOf(err error) Stage {
+	var e *stageError
+	if tea.AsError(err, &e) {
+		return e.stage
+	}
+
+	return ""
+}
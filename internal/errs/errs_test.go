@@ -0,0 +1,100 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/pghq/go-tea"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default message", func(t *testing.T) {
+		err := New(ErrNotFound)
+		assert.Equal(t, "not found", err.Error())
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.False(t, errors.Is(err, ErrClosed))
+	})
+
+	t.Run("custom message", func(t *testing.T) {
+		err := New(ErrInvalidInput, "invalid ip")
+		assert.Equal(t, "invalid ip", err.Error())
+		assert.Equal(t, "invalid ip", fmt.Sprintf("%v", err))
+		assert.Contains(t, fmt.Sprintf("%+v", err), "errs.New")
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+		assert.True(t, tea.IsError(err, ErrInvalidInput))
+
+		var e *Error
+		assert.True(t, errors.As(err, &e))
+		assert.Equal(t, ErrInvalidInput, e.Kind)
+	})
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+
+	cause := &os.PathError{Op: "open", Path: "data", Err: os.ErrNotExist}
+	err := Wrap(ErrRejected, cause, "validation failed")
+	assert.Equal(t, "validation failed: open data: file does not exist", err.Error())
+	assert.True(t, errors.Is(err, ErrRejected))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.True(t, tea.IsError(err, ErrRejected))
+
+	var pe *os.PathError
+	assert.True(t, errors.As(err, &pe))
+	assert.Same(t, cause, pe)
+
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, ErrRejected, e.Kind)
+	assert.True(t, tea.IsError(AtStage(StageValidate, err), os.ErrNotExist))
+	assert.Equal(t, "open data: file does not exist", Wrap(ErrRejected, cause).Error())
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	t.Run("lookup error", func(t *testing.T) {
+		err := New(ErrNotReady)
+		assert.Same(t, err, Trace(tea.Stacktrace(err)))
+		assert.True(t, errors.Is(Trace(tea.Stacktrace(err)), ErrNotReady))
+	})
+
+	t.Run("context error", func(t *testing.T) {
+		err := Trace(tea.Stacktrace(context.DeadlineExceeded))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, context.DeadlineExceeded.Error(), err.Error())
+	})
+
+	t.Run("other error", func(t *testing.T) {
+		err := errors.New("an error has occurred")
+		assert.True(t, tea.IsError(Trace(err), err))
+		assert.False(t, errors.Is(Trace(err), ErrNotFound))
+	})
+}
+
+func TestAtStage(t *testing.T) {
+	t.Parallel()
+
+	t.Run("unknown stage", func(t *testing.T) {
+		assert.Equal(t, Stage(""), StageOf(tea.Err("an error has occurred")))
+		assert.Equal(t, Stage(""), StageOf(nil))
+	})
+
+	t.Run("records first stage", func(t *testing.T) {
+		err := AtStage(StageIndex, tea.Stacktrace(AtStage(StageParse, tea.Err("bad record"))))
+		assert.Equal(t, StageParse, StageOf(err))
+		assert.Equal(t, "bad record", err.Error())
+	})
+
+	t.Run("keeps cause", func(t *testing.T) {
+		cause := errors.New("not modified")
+		err := AtStage(StageDownload, tea.Stacktrace(cause))
+		assert.True(t, tea.IsError(err, cause))
+	})
+}
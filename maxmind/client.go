@@ -2,13 +2,14 @@ package maxmind
 
 import (
 	"archive/tar"
-	"bytes"
 	"compress/gzip"
 	"context"
-	"io/ioutil"
+	"io"
 	"net"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
@@ -16,6 +17,8 @@ import (
 	"github.com/pghq/go-tea"
 
 	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/internal/errs"
+	"github.com/pghq/go-way/source"
 )
 
 const (
@@ -23,21 +26,67 @@ const (
 	PositiveTTL = 30 * time.Minute
 )
 
+var (
+	// ErrNotReady is returned by lookups on a client that has not been loaded yet
+	ErrNotReady = errs.ErrNotReady
+
+	// ErrNotFound is returned by lookups that match no city
+	ErrNotFound = errs.ErrNotFound
+
+	// ErrInvalidInput is returned by lookups with malformed arguments
+	ErrInvalidInput = errs.ErrInvalidInput
+
+	// ErrClosed is returned by lookups on a closed client
+	ErrClosed = errs.ErrClosed
+)
+
+// Error is a lookup error that can be inspected with errors.Is and errors.As
+type Error = errs.Error
+
 // Client for Maxmind
+// Size is the size of the memory mapped database in bytes
+// the database is spooled to a temporary file which is unlinked once mapped, where the platform allows it (e.g., unix)
+// elsewhere the file takes Size bytes of disk until the client is closed
 type Client struct {
-	IPCount int
-	reader  *geoip2.Reader
-	db      *ark.Mapper
+	IPCount      int
+	Validator    client.Validator
+	BuildEpoch   time.Time
+	DatabaseType string
+	Size         int64
+	reader       *geoip2.Reader
+	path         string
+	mu           sync.RWMutex
+	closed       bool
+	db           *ark.Mapper
 }
 
 // Get city by id
 func (c *Client) Get(ip net.IP) (*geoip2.City, error) {
+	return c.GetContext(context.Background(), ip)
+}
+
+// GetContext gets city by id, honoring cancellation of the context
+func (c *Client) GetContext(ctx context.Context, ip net.IP) (*geoip2.City, error) {
+	span := tea.Nest(ctx, "maxmind")
+	defer span.End()
+
+	span.Tag("lookup", "get")
 	if c == nil {
-		return nil, tea.ErrNotFound("client not ready")
+		return nil, errs.New(ErrNotReady, "client not ready")
+	}
+
+	if ip == nil {
+		return nil, errs.New(ErrInvalidInput, "invalid ip")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return nil, errs.New(ErrClosed, "client closed")
 	}
 
 	var city *geoip2.City
-	return city, c.db.Do(context.Background(), func(tx ark.Txn) error {
+	err := c.db.Do(span, func(tx ark.Txn) error {
 		var cy geoip2.City
 		if err := tx.Get("", ip.String(), &cy); err == nil {
 			city = &cy
@@ -50,38 +99,77 @@ func (c *Client) Get(ip net.IP) (*geoip2.City, error) {
 		}
 
 		if c == nil || c.City.GeoNameID == 0 {
-			return tea.ErrNotFound("not found")
+			return errs.New(ErrNotFound, "ip not found")
 		}
 
 		city = c
 		return tx.InsertTTL("", ip.String(), city, PositiveTTL)
 	})
+
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return city, nil
 }
 
-// Close the reader
+// Close the reader and remove the database file
+// waits for in-flight lookups to finish
 func (c *Client) Close() error {
-	return c.reader.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	err := c.reader.Close()
+	if c.path != "" {
+		_ = os.Remove(c.path)
+	}
+
+	return err
+}
+
+// Refresh creates a new client if the database has changed since this client was created
+// returns client.ErrNotModified otherwise
+func (c *Client) Refresh(ctx context.Context, src source.Source) (*Client, error) {
+	var since client.Validator
+	if c != nil {
+		since = c.Validator
+	}
+
+	return newClient(ctx, src, since)
 }
 
 // NewClient creates a new maxmind client
 func NewClient(ctx context.Context, uri string) (*Client, error) {
-	resp, err := client.Get(ctx, uri)
+	return Open(ctx, source.URL(uri))
+}
+
+// Open creates a new maxmind client from a source
+func Open(ctx context.Context, src source.Source) (*Client, error) {
+	return newClient(ctx, src, client.Validator{})
+}
+
+// newClient creates a new maxmind client if the database has changed since the validator
+func newClient(ctx context.Context, src source.Source, since client.Validator) (*Client, error) {
+	body, validator, err := src.Open(ctx, since)
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, errs.AtStage(errs.StageDownload, err)
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
-	b, err := ioutil.ReadAll(resp.Body)
-	stream, err := gzip.NewReader(bytes.NewReader(b))
+	stream, err := gzip.NewReader(body)
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, errs.AtStage(errs.StageDecompress, err)
 	}
 
 	tr := tar.NewReader(stream)
 	for {
 		header, err := tr.Next()
 		if err != nil {
-			return nil, tea.Stacktrace(err)
+			return nil, errs.AtStage(errs.StageDecompress, err)
 		}
 
 		base := filepath.Base(header.Name)
@@ -90,17 +178,53 @@ func NewClient(ctx context.Context, uri string) (*Client, error) {
 		}
 	}
 
-	b, _ = ioutil.ReadAll(tr)
-	reader, err := geoip2.FromBytes(b)
+	path, size, err := spool(tr)
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, errs.AtStage(errs.StageDecompress, err)
+	}
+
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, errs.AtStage(errs.StageParse, err)
+	}
+
+	// the mapping outlives the file, so nothing is left behind by a client that is never closed
+	if os.Remove(path) == nil {
+		path = ""
 	}
 
+	metadata := reader.Metadata()
 	c := Client{
-		IPCount: int(reader.Metadata().NodeCount),
-		reader:  reader,
-		db:      ark.New("memory://"),
+		IPCount:      int(metadata.NodeCount),
+		Validator:    validator,
+		BuildEpoch:   time.Unix(int64(metadata.BuildEpoch), 0).UTC(),
+		DatabaseType: metadata.DatabaseType,
+		Size:         size,
+		reader:       reader,
+		path:         path,
+		db:           ark.New("memory://"),
 	}
 
 	return &c, nil
 }
+
+// spool the database to a temporary file so that it can be memory mapped
+func spool(r io.Reader) (string, int64, error) {
+	f, err := os.CreateTemp("", "maxmind-*.mmdb")
+	if err != nil {
+		return "", 0, tea.Stacktrace(err)
+	}
+
+	size, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", 0, tea.Stacktrace(err)
+	}
+
+	return f.Name(), size, nil
+}
@@ -5,14 +5,21 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/pghq/go-ark/database"
+	"github.com/pghq/go-tea"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/internal/errs"
+	"github.com/pghq/go-way/source"
 )
 
 func TestDB_Get(t *testing.T) {
@@ -21,12 +28,12 @@ func TestDB_Get(t *testing.T) {
 	t.Run("not ready", func(t *testing.T) {
 		var c *Client
 		_, err := c.Get(net.ParseIP("1.2.3.4"))
-		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, ErrNotReady))
 	})
 
 	t.Run("bad open", func(t *testing.T) {
 		_, err := NewClient(context.TODO(), "does-not-exist")
-		assert.NotNil(t, err)
+		assert.Equal(t, errs.StageDownload, errs.StageOf(err))
 	})
 
 	t.Run("open timeout", func(t *testing.T) {
@@ -43,7 +50,7 @@ func TestDB_Get(t *testing.T) {
 		}))
 
 		_, err := NewClient(context.TODO(), s.URL)
-		assert.NotNil(t, err)
+		assert.Equal(t, errs.StageDecompress, errs.StageOf(err))
 	})
 
 	t.Run("bad tar", func(t *testing.T) {
@@ -84,17 +91,53 @@ func TestDB_Get(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, c)
 
+	t.Run("not modified", func(t *testing.T) {
+		_, err := c.Refresh(context.TODO(), source.URL(s.URL))
+		assert.NotNil(t, err)
+		assert.True(t, tea.IsError(err, client.ErrNotModified))
+	})
+
+	t.Run("refresh without client", func(t *testing.T) {
+		var c *Client
+		c, err := c.Refresh(context.TODO(), source.URL(s.URL))
+		assert.Nil(t, err)
+		assert.NotNil(t, c)
+	})
+
+	t.Run("from file", func(t *testing.T) {
+		c, err := Open(context.TODO(), source.File("../testdata/GeoLite2-City.tgz"))
+		assert.Nil(t, err)
+		assert.NotNil(t, c)
+		assert.Equal(t, "GeoIP2-City", c.DatabaseType)
+		assert.False(t, c.BuildEpoch.IsZero())
+		assert.Positive(t, c.Size)
+	})
+
 	t.Run("closed client", func(t *testing.T) {
 		c, _ := NewClient(context.TODO(), s.URL)
-		c.Close()
+		assert.Nil(t, c.Close())
+		assert.Nil(t, c.Close())
 
 		_, err := c.Get(net.ParseIP("81.2.69.142"))
-		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, ErrClosed))
+	})
+
+	t.Run("invalid ip", func(t *testing.T) {
+		_, err := c.Get(nil)
+		assert.True(t, errors.Is(err, ErrInvalidInput))
 	})
 
 	t.Run("not found", func(t *testing.T) {
 		_, err := c.Get(net.ParseIP("192.168.1.1"))
-		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		_, err := c.GetContext(ctx, net.ParseIP("81.2.69.142"))
+		assert.True(t, errors.Is(err, context.Canceled))
 	})
 
 	t.Run("found", func(t *testing.T) {
@@ -111,6 +154,48 @@ func TestDB_Get(t *testing.T) {
 	})
 }
 
+func TestClient_TempFiles(t *testing.T) {
+	// not parallel, the temp dir is set for the whole process
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	t.Run("removed on error", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var b bytes.Buffer
+			gz := gzip.NewWriter(&b)
+			tw := tar.NewWriter(gz)
+			tw.WriteHeader(&tar.Header{
+				Name: "bad.mmdb",
+				Mode: 0600,
+				Size: int64(len("bad body")),
+			})
+			tw.Write([]byte("bad body"))
+			tw.Close()
+			gz.Close()
+			w.Write(b.Bytes())
+		}))
+
+		_, err := NewClient(context.TODO(), s.URL)
+		assert.NotNil(t, err)
+		files, _ := os.ReadDir(dir)
+		assert.Empty(t, files)
+	})
+
+	t.Run("removed once mapped", func(t *testing.T) {
+		s := serve("../testdata/GeoLite2-City.tgz")
+		c, err := NewClient(context.TODO(), s.URL)
+		assert.Nil(t, err)
+		assert.Empty(t, c.path)
+		files, _ := os.ReadDir(dir)
+		assert.Empty(t, files)
+
+		loc, err := c.Get(net.ParseIP("81.2.69.142"))
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
+		assert.Nil(t, c.Close())
+	})
+}
+
 func serve(path string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, path)
@@ -0,0 +1,155 @@
+// Package source provides origins for GeoNames and MaxMind datasets.
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/client"
+)
+
+// Source is an origin for a dataset
+type Source interface {
+	// Open the dataset if it has changed since the validator
+	// returns client.ErrNotModified otherwise
+	Open(ctx context.Context, since client.Validator) (io.ReadCloser, client.Validator, error)
+}
+
+// HTTP creates a source for a http(s) url
+func HTTP(url string, opts ...client.Option) Source {
+	return httpSource{url: url, opts: opts}
+}
+
+// File creates a source for a local file
+func File(path string) Source {
+	return fileSource{path: path}
+}
+
+// FS creates a source for a file within a file system (e.g., embed.FS)
+func FS(fsys fs.FS, name string) Source {
+	return fsSource{fsys: fsys, name: name}
+}
+
+// URL creates a source for a http://, https:// or file:// url
+func URL(uri string, opts ...client.Option) Source {
+	return urlSource{uri: uri, opts: opts}
+}
+
+// httpSource is a dataset served over http(s)
+type httpSource struct {
+	url  string
+	opts []client.Option
+}
+
+func (s httpSource) String() string {
+	return client.Redact(s.url)
+}
+
+func (s httpSource) Open(ctx context.Context, since client.Validator) (io.ReadCloser, client.Validator, error) {
+	resp, err := client.Get(ctx, s.url, append(s.opts, client.Since(since))...)
+	if err != nil {
+		return nil, client.Validator{}, tea.Stacktrace(err)
+	}
+
+	return resp.Body, client.NewValidator(resp), nil
+}
+
+// fileSource is a dataset on the local file system
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) String() string {
+	return s.path
+}
+
+func (s fileSource) Open(ctx context.Context, since client.Validator) (io.ReadCloser, client.Validator, error) {
+	return open(ctx, since, func() (fs.File, error) { return os.Open(s.path) })
+}
+
+// fsSource is a dataset within a file system
+type fsSource struct {
+	fsys fs.FS
+	name string
+}
+
+func (s fsSource) String() string {
+	return s.name
+}
+
+func (s fsSource) Open(ctx context.Context, since client.Validator) (io.ReadCloser, client.Validator, error) {
+	return open(ctx, since, func() (fs.File, error) { return s.fsys.Open(s.name) })
+}
+
+// urlSource is a dataset resolved by url scheme
+type urlSource struct {
+	uri  string
+	opts []client.Option
+}
+
+func (s urlSource) String() string {
+	return client.Redact(s.uri)
+}
+
+func (s urlSource) Open(ctx context.Context, since client.Validator) (io.ReadCloser, client.Validator, error) {
+	u, err := url.Parse(s.uri)
+	if err != nil {
+		return nil, client.Validator{}, tea.Stacktrace(err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return HTTP(s.uri, s.opts...).Open(ctx, since)
+	case "file":
+		host := u.Host
+		if host == "localhost" {
+			host = ""
+		}
+
+		return File(filepath.FromSlash(host+u.Path)).Open(ctx, since)
+	default:
+		return nil, client.Validator{}, tea.Errf("unsupported source scheme %q", u.Scheme)
+	}
+}
+
+// open a file if it has changed since the validator
+func open(ctx context.Context, since client.Validator, fn func() (fs.File, error)) (io.ReadCloser, client.Validator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, client.Validator{}, tea.Stacktrace(err)
+	}
+
+	f, err := fn()
+	if err != nil {
+		return nil, client.Validator{}, tea.Stacktrace(err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, client.Validator{}, tea.Stacktrace(err)
+	}
+
+	validator := newValidator(info)
+	if !since.IsZero() && since == validator {
+		_ = f.Close()
+		return nil, client.Validator{}, tea.Stacktrace(client.ErrNotModified)
+	}
+
+	return f, validator, nil
+}
+
+// newValidator creates a validator from file info
+func newValidator(info fs.FileInfo) client.Validator {
+	return client.Validator{
+		ETag:         fmt.Sprintf(`"%x.%x-%x"`, info.ModTime().Unix(), info.ModTime().Nanosecond(), info.Size()),
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}
+}
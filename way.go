@@ -14,13 +14,22 @@
 package way
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pghq/go-red"
+	"github.com/pghq/go-tea"
 
+	"github.com/pghq/go-way/client"
 	"github.com/pghq/go-way/geonames"
+	"github.com/pghq/go-way/internal/errs"
 	"github.com/pghq/go-way/maxmind"
+	"github.com/pghq/go-way/source"
 )
 
 const (
@@ -30,23 +39,93 @@ const (
 	// DefaultMaxmindLocation is the default origin location for the Maxmind export
 	DefaultMaxmindLocation = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-City&license_key=YOUR_LICENSE_KEY&suffix=tar.gz"
 
+	// MaxmindDownloadLocation is the origin location for Maxmind exports downloaded with an account (%s is the edition)
+	MaxmindDownloadLocation = "https://download.maxmind.com/geoip/databases/%s/download?suffix=tar.gz"
+
+	// DefaultMaxmindEdition is the default edition of the Maxmind export
+	DefaultMaxmindEdition = "GeoLite2-City"
+
 	// DefaultRefreshTimeout is the default wait time for refreshing locations
 	DefaultRefreshTimeout = 5 * time.Minute
+
+	// DefaultDrainTimeout is the default wait time before closing replaced datasets
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNotReady is returned by lookups before the datasets have been loaded
+	ErrNotReady = errs.ErrNotReady
+
+	// ErrNotFound is returned by lookups that match no location
+	ErrNotFound = errs.ErrNotFound
+
+	// ErrInvalidInput is returned by lookups with malformed arguments, and reported for bad refresh schedules
+	ErrInvalidInput = errs.ErrInvalidInput
+
+	// ErrClosed is returned by lookups on a closed radar
+	ErrClosed = errs.ErrClosed
+
+	// ErrRejected is returned by refreshes of a dataset that failed validation (the previous dataset is kept)
+	ErrRejected = errs.ErrRejected
+
+	// ErrNoPrevious is returned by Rollback when no dataset has a previous version to restore
+	ErrNoPrevious = errs.ErrNoPrevious
+
+	// ErrNotConfigured is returned by IP lookups when maxmind is not configured
+	ErrNotConfigured = errs.ErrNotConfigured
 )
 
+// Error is a lookup error that can be inspected with errors.Is and errors.As
+type Error = errs.Error
+
 // Radar is a postal level geo-lookup service.
 type Radar struct {
-	userAgent        string
-	geonamesLocation string
-	maxmindLocation  string
-	maxmindKey       string
-	countries        []string
-	refreshTimeout   time.Duration
-	errors           chan error
-	refreshes        chan *sync.WaitGroup
-	bg               *red.Worker
-	geonames         *geonames.Client
-	maxmind          *maxmind.Client
+	userAgent          string
+	header             http.Header
+	httpClient         *http.Client
+	transport          http.RoundTripper
+	proxy              func(*http.Request) (*url.URL, error)
+	retries            int
+	backoff            time.Duration
+	maxBackoff         time.Duration
+	geonamesLocation   string
+	geonamesSource     source.Source
+	maxmindLocation    string
+	maxmindSource      source.Source
+	maxmindKey         string
+	maxmindAccount     string
+	maxmindEdition     string
+	geonamesMirrors    []Mirror
+	maxmindMirrors     []Mirror
+	countries          []string
+	requiredCountries  []string
+	geonamesValidators []func(gc *geonames.Client) error
+	maxmindValidators  []func(mc *maxmind.Client) error
+	asyncStart         bool
+	disableRollback    bool
+	drainTimeout       time.Duration
+	refreshJitter      time.Duration
+	geonamesFeed       feed
+	maxmindFeed        feed
+	startHandlers      []func(RefreshStart)
+	successHandlers    []func(RefreshSuccess)
+	errorHandlers      []func(RefreshError)
+	events             dispatcher
+	clock              Clock
+	mu                 sync.Mutex
+	ctx                context.Context
+	cancel             context.CancelFunc
+	closed             int32
+	running            sync.WaitGroup
+	ready              chan struct{}
+	readyOnce          sync.Once
+	errors             chan error
+	bg                 *red.Worker
+	geonames           atomic.Value // *geonames.Client
+	maxmind            atomic.Value // *maxmind.Client
+	previousGeonames   *geonames.Client
+	previousMaxmind    *maxmind.Client
+	draining           map[io.Closer]*time.Timer
 }
 
 // Error gets any background errors
@@ -67,59 +146,310 @@ func (r *Radar) sendError(err error) {
 	}
 }
 
+// geonamesClient is the geonames client for lookups
+func (r *Radar) geonamesClient() (*geonames.Client, error) {
+	if r.isClosed() {
+		return nil, errs.New(ErrClosed, "radar closed")
+	}
+
+	gc := r.loadGeonames()
+	if gc == nil {
+		return nil, errs.New(ErrNotReady, "radar not ready")
+	}
+
+	return gc, nil
+}
+
+// maxmindClient is the maxmind client for lookups
+func (r *Radar) maxmindClient() (*maxmind.Client, error) {
+	if r.isClosed() {
+		return nil, errs.New(ErrClosed, "radar closed")
+	}
+
+	if r.maxmindOrigin() == nil {
+		return nil, errs.New(ErrNotConfigured, "maxmind not configured")
+	}
+
+	mc := r.loadMaxmind()
+	if mc == nil {
+		return nil, errs.New(ErrNotReady, "radar not ready")
+	}
+
+	return mc, nil
+}
+
+// loadGeonames loads the current geonames client (nil if not ready)
+func (r *Radar) loadGeonames() *geonames.Client {
+	gc, _ := r.geonames.Load().(*geonames.Client)
+	return gc
+}
+
+// loadMaxmind loads the current maxmind client (nil if not ready)
+func (r *Radar) loadMaxmind() *maxmind.Client {
+	mc, _ := r.maxmind.Load().(*maxmind.Client)
+	return mc
+}
+
+// Ready is closed once every configured dataset has been loaded
+func (r *Radar) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// WaitReady waits until every configured dataset has been loaded
+func (r *Radar) WaitReady(ctx context.Context) error {
+	select {
+	case <-r.ready:
+		return nil
+	case <-r.ctx.Done():
+		return errs.New(ErrClosed, "radar closed")
+	case <-ctx.Done():
+		return tea.Stacktrace(ctx.Err())
+	}
+}
+
+// markReady signals readiness if every configured dataset has been loaded
+func (r *Radar) markReady() {
+	if r.loadGeonames() == nil || (r.maxmindOrigin() != nil && r.loadMaxmind() == nil) {
+		return
+	}
+
+	r.readyOnce.Do(func() {
+		close(r.ready)
+	})
+}
+
+// isClosed checks if the radar has been closed
+func (r *Radar) isClosed() bool {
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+// Close stops background refreshes, cancels any in-flight refresh and releases the datasets (including replaced ones still draining)
+// lookups after closing return ErrClosed
+func (r *Radar) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.isClosed() {
+		r.mu.Unlock()
+		return nil
+	}
+
+	atomic.StoreInt32(&r.closed, 1)
+	var pending []*refreshRun
+	for _, f := range []*feed{&r.geonamesFeed, &r.maxmindFeed} {
+		if f.run != nil && !f.run.started {
+			pending = append(pending, f.run)
+			f.run = nil
+		}
+	}
+	r.mu.Unlock()
+
+	for _, run := range pending {
+		run.finish(errs.New(ErrClosed, "radar closed"))
+	}
+
+	r.cancel()
+	r.bg.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return tea.Stacktrace(ctx.Err())
+	}
+
+	var err error
+	if gc, _ := r.geonames.Swap((*geonames.Client)(nil)).(*geonames.Client); gc != nil {
+		err = gc.Close()
+	}
+
+	if mc, _ := r.maxmind.Swap((*maxmind.Client)(nil)).(*maxmind.Client); mc != nil {
+		if cerr := mc.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	r.mu.Lock()
+	previousGeonames, previousMaxmind := r.previousGeonames, r.previousMaxmind
+	r.previousGeonames, r.previousMaxmind = nil, nil
+	draining := r.draining
+	r.draining = nil
+	r.mu.Unlock()
+
+	// replaced clients are closed now rather than once drained, closing waits for their in-flight lookups
+	for c, timer := range draining {
+		timer.Stop()
+		_ = c.Close()
+	}
+
+	if previousGeonames != nil {
+		_ = previousGeonames.Close()
+	}
+
+	if previousMaxmind != nil {
+		_ = previousMaxmind.Close()
+	}
+
+	if err != nil {
+		return tea.Stacktrace(err)
+	}
+
+	return nil
+}
+
 // New creates a new radar instance.
 func New(opts ...RadarOption) *Radar {
+	ctx, cancel := context.WithCancel(context.Background())
 	r := Radar{
-		refreshTimeout:   DefaultRefreshTimeout,
+		ctx:              ctx,
+		cancel:           cancel,
+		drainTimeout:     DefaultDrainTimeout,
+		retries:          client.DefaultRetries,
+		backoff:          client.DefaultBackoff,
+		maxBackoff:       client.DefaultMaxBackoff,
+		geonamesFeed:     feed{dataset: GeonamesDataset, timeout: DefaultRefreshTimeout},
+		maxmindFeed:      feed{dataset: MaxmindDataset, timeout: DefaultRefreshTimeout},
 		geonamesLocation: DefaultGeonamesLocation,
 		maxmindLocation:  DefaultMaxmindLocation,
+		maxmindEdition:   DefaultMaxmindEdition,
+		clock:            systemClock{},
+		ready:            make(chan struct{}),
 		errors:           make(chan error, 1),
-		refreshes:        make(chan *sync.WaitGroup, 1),
 	}
 
-	bg := red.NewWorker("way", r.refreshJob)
+	bg := red.NewWorker("way", r.refreshJob, r.scheduleJob)
 	for _, opt := range opts {
 		opt(&r)
 	}
 
+	r.configureHTTP()
+	r.configureOrigins()
+	for _, f := range r.feeds() {
+		if err := r.configureSchedule(f); err != nil {
+			r.fail(f.dataset, "", err)
+		}
+	}
+
 	r.bg = bg
 	go bg.Start()
 
-	r.Refresh()
+	if r.asyncStart {
+		go r.Refresh()
+	} else {
+		r.Refresh()
+	}
+
+	now := r.clock.Now()
+	for _, f := range r.feeds() {
+		r.scheduleNext(f, now)
+	}
+
 	return &r
 }
 
 // RadarOption to configure custom radar
 type RadarOption func(r *Radar)
 
-// RefreshTimeout sets a custom refresh timeout
+// RefreshTimeout sets a custom refresh timeout for every dataset
 func RefreshTimeout(o time.Duration) RadarOption {
 	return func(r *Radar) {
-		r.refreshTimeout = o
+		GeonamesRefreshTimeout(o)(r)
+		MaxmindRefreshTimeout(o)(r)
+	}
+}
+
+// GeonamesRefreshTimeout sets a custom refresh timeout for the geonames db
+func GeonamesRefreshTimeout(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.geonamesFeed.timeout = o
+	}
+}
+
+// MaxmindRefreshTimeout sets a custom refresh timeout for the maxmind db
+func MaxmindRefreshTimeout(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.maxmindFeed.timeout = o
 	}
 }
 
-// GeonamesLocation sets a custom location to refresh geonames db from
+// AsyncStart loads the datasets in the background instead of blocking New
+// lookups return ErrNotReady until the radar is ready (see Radar.Ready)
+func AsyncStart() RadarOption {
+	return func(r *Radar) {
+		r.asyncStart = true
+	}
+}
+
+// GeonamesLocation sets a custom location (http://, https:// or file:// url) to refresh geonames db from
 func GeonamesLocation(o string) RadarOption {
 	return func(r *Radar) {
 		r.geonamesLocation = o
+		r.geonamesSource = nil
+		r.geonamesMirrors = nil
+	}
+}
+
+// GeonamesSource sets a custom source to refresh geonames db from
+func GeonamesSource(o source.Source) RadarOption {
+	return func(r *Radar) {
+		r.geonamesSource = o
+		r.geonamesMirrors = nil
 	}
 }
 
-// MaxmindLocation sets a custom location to refresh maxmind db from
+// MaxmindLocation sets a custom location (http://, https:// or file:// url) to refresh maxmind db from
 func MaxmindLocation(o string) RadarOption {
 	return func(r *Radar) {
 		r.maxmindLocation = o
+		r.maxmindSource = nil
+		r.maxmindMirrors = nil
+	}
+}
+
+// MaxmindSource sets a custom source to refresh maxmind db from
+func MaxmindSource(o source.Source) RadarOption {
+	return func(r *Radar) {
+		r.maxmindSource = o
+		r.maxmindMirrors = nil
 	}
 }
 
 // MaxmindKey sets a custom maxmind licence key
+// without an account, the key is sent in the url of the legacy download endpoint
+//
+// Deprecated: use MaxmindAccount, which keeps the key out of urls.
 func MaxmindKey(o string) RadarOption {
 	return func(r *Radar) {
 		r.maxmindKey = o
 	}
 }
 
+// MaxmindAccount sets a maxmind account id and licence key for downloads authenticated with http basic auth
+// the credentials are only sent to download.maxmind.com, and not to the storage it redirects to
+func MaxmindAccount(id, key string) RadarOption {
+	return func(r *Radar) {
+		r.maxmindAccount = id
+		r.maxmindKey = key
+	}
+}
+
+// MaxmindEdition sets a custom edition of the maxmind export to download (e.g., GeoIP2-City)
+func MaxmindEdition(o string) RadarOption {
+	return func(r *Radar) {
+		r.maxmindEdition = o
+	}
+}
+
+// DrainTimeout sets a custom wait time before closing datasets replaced by a refresh
+func DrainTimeout(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.drainTimeout = o
+	}
+}
+
 // Countries supported
 func Countries(o ...string) RadarOption {
 	return func(r *Radar) {
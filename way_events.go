@@ -0,0 +1,254 @@
+package way
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/internal/errs"
+)
+
+// Dataset refreshed by the radar
+type Dataset string
+
+const (
+	// GeonamesDataset is the GeoNames postal code export
+	GeonamesDataset Dataset = "geonames"
+
+	// MaxmindDataset is the MaxMind city database
+	MaxmindDataset Dataset = "maxmind"
+)
+
+// Stage of a refresh
+type Stage = errs.Stage
+
+const (
+	// StageDownload is fetching the dataset from its source
+	StageDownload = errs.StageDownload
+
+	// StageDecompress is extracting the dataset from its archive
+	StageDecompress = errs.StageDecompress
+
+	// StageParse is reading the records of the dataset
+	StageParse = errs.StageParse
+
+	// StageIndex is storing and indexing the records of the dataset
+	StageIndex = errs.StageIndex
+
+	// StageValidate is checking the dataset before it replaces the current one
+	StageValidate = errs.StageValidate
+)
+
+// RefreshStart is the start of a dataset refresh
+type RefreshStart struct {
+	// Source is the dataset being refreshed
+	Source Dataset
+
+	// Time the refresh started
+	Time time.Time
+}
+
+// RefreshSuccess is a refresh that replaced a dataset
+type RefreshSuccess struct {
+	// Source is the dataset that was replaced
+	Source Dataset
+
+	// Time the new dataset was swapped in
+	Time time.Time
+
+	// OldCount is the number of locations (GeoNames) or network nodes (MaxMind) in the replaced dataset
+	OldCount int
+
+	// NewCount is the number of locations (GeoNames) or network nodes (MaxMind) in the new dataset
+	NewCount int
+
+	// OldVersion is the validator of the replaced dataset (zero if there was none)
+	OldVersion client.Validator
+
+	// NewVersion is the validator of the new dataset
+	NewVersion client.Validator
+}
+
+// RefreshError is a failed refresh of a dataset
+type RefreshError struct {
+	// Source is the dataset that failed to refresh
+	Source Dataset
+
+	// Stage is the step of the refresh that failed (empty if unknown)
+	Stage Stage
+
+	// Origin is the source the dataset failed to refresh from (secrets are redacted)
+	Origin string
+
+	// Failover reports whether the refresh went on to the next mirror
+	Failover bool
+
+	// Time of the failure
+	Time time.Time
+
+	// Err is the cause of the failure
+	Err error
+}
+
+// Error implements the error interface
+func (e RefreshError) Error() string {
+	if e.Stage == "" {
+		return fmt.Sprintf("%s refresh: %s", e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("%s %s: %s", e.Source, e.Stage, e.Err)
+}
+
+// Unwrap gets the cause of the failure
+func (e RefreshError) Unwrap() error {
+	return e.Err
+}
+
+// Is checks if the cause of the failure is the target (e.g., ErrRejected)
+func (e RefreshError) Is(target error) bool {
+	return tea.IsError(e.Err, target)
+}
+
+// As finds the first error in the cause of the failure that matches the target (e.g., an error returned by a validator)
+func (e RefreshError) As(target interface{}) bool {
+	return tea.AsError(e.Err, target)
+}
+
+// OnRefreshStart subscribes to the start of dataset refreshes
+// handlers are called in order, one event at a time, without blocking refreshes
+func (r *Radar) OnRefreshStart(fn func(RefreshStart)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startHandlers = append(r.startHandlers, fn)
+}
+
+// OnRefreshSuccess subscribes to refreshes that replace a dataset (refreshes finding the dataset unchanged are not reported)
+// handlers are called in order, one event at a time, once the new dataset is serving lookups
+func (r *Radar) OnRefreshSuccess(fn func(RefreshSuccess)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.successHandlers = append(r.successHandlers, fn)
+}
+
+// OnRefreshFailed subscribes to failed refreshes (same as OnError)
+func (r *Radar) OnRefreshFailed(fn func(RefreshError)) {
+	r.OnError(fn)
+}
+
+// OnError subscribes to failed refreshes
+// handlers are called in order, one event at a time, without blocking refreshes
+func (r *Radar) OnError(fn func(RefreshError)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errorHandlers = append(r.errorHandlers, fn)
+}
+
+// RefreshStartHandler subscribes to the start of dataset refreshes, including the initial refresh
+func RefreshStartHandler(fn func(RefreshStart)) RadarOption {
+	return func(r *Radar) {
+		r.startHandlers = append(r.startHandlers, fn)
+	}
+}
+
+// RefreshSuccessHandler subscribes to refreshes that replace a dataset, including the initial refresh
+func RefreshSuccessHandler(fn func(RefreshSuccess)) RadarOption {
+	return func(r *Radar) {
+		r.successHandlers = append(r.successHandlers, fn)
+	}
+}
+
+// ErrorHandler subscribes to failed refreshes, including the initial refresh
+func ErrorHandler(fn func(RefreshError)) RadarOption {
+	return func(r *Radar) {
+		r.errorHandlers = append(r.errorHandlers, fn)
+	}
+}
+
+// start publishes the start of a dataset refresh
+func (r *Radar) start(dataset Dataset) {
+	r.mu.Lock()
+	event := RefreshStart{Source: dataset, Time: r.clock.Now()}
+	handlers := append([]func(RefreshStart){}, r.startHandlers...)
+	r.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn := fn
+		r.events.dispatch(func() { fn(event) })
+	}
+}
+
+// succeed publishes a refresh that replaced a dataset
+func (r *Radar) succeed(event RefreshSuccess) {
+	r.mu.Lock()
+	event.Time = r.clock.Now()
+	handlers := append([]func(RefreshSuccess){}, r.successHandlers...)
+	r.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn := fn
+		r.events.dispatch(func() { fn(event) })
+	}
+}
+
+// fail publishes a failed refresh of a dataset from its last origin
+func (r *Radar) fail(dataset Dataset, origin string, err error) RefreshError {
+	return r.publish(RefreshError{Source: dataset, Origin: origin, Err: err})
+}
+
+// publish a refresh error, recording its stage and time
+// failovers are not sent as background errors since the refresh goes on
+func (r *Radar) publish(event RefreshError) RefreshError {
+	r.mu.Lock()
+	event.Stage, event.Time = errs.StageOf(event.Err), r.clock.Now()
+	handlers := append([]func(RefreshError){}, r.errorHandlers...)
+	r.mu.Unlock()
+
+	if !event.Failover {
+		r.sendError(event.Err)
+	}
+
+	for _, fn := range handlers {
+		fn := fn
+		r.events.dispatch(func() { fn(event) })
+	}
+
+	return event
+}
+
+// dispatcher delivers events in order without blocking the publisher
+type dispatcher struct {
+	mu      sync.Mutex
+	queue   []func()
+	pumping bool
+}
+
+// dispatch an event
+func (d *dispatcher) dispatch(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queue = append(d.queue, fn)
+	if !d.pumping {
+		d.pumping = true
+		go d.pump()
+	}
+}
+
+// pump delivers queued events until there are none left
+func (d *dispatcher) pump() {
+	for {
+		d.mu.Lock()
+		if len(d.queue) == 0 {
+			d.pumping = false
+			d.mu.Unlock()
+			return
+		}
+
+		fn := d.queue[0]
+		d.queue = d.queue[1:]
+		d.mu.Unlock()
+		fn()
+	}
+}
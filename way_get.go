@@ -1,25 +1,35 @@
 package way
 
 import (
+	"context"
 	"net"
 	"strings"
 
-	"github.com/pghq/go-tea"
-
 	"github.com/pghq/go-way/country"
 	"github.com/pghq/go-way/geonames"
+	"github.com/pghq/go-way/internal/errs"
 )
 
 // IP lookup
 func (r *Radar) IP(addr string) (*geonames.Location, error) {
+	return r.IPContext(context.Background(), addr)
+}
+
+// IPContext ip lookup, honoring cancellation of the context
+func (r *Radar) IPContext(ctx context.Context, addr string) (*geonames.Location, error) {
 	ip := net.ParseIP(addr)
 	if ip == nil {
-		return nil, tea.Err("invalid ip")
+		return nil, errs.New(ErrInvalidInput, "invalid ip")
 	}
 
-	city, err := r.maxmind.Get(ip)
+	mc, err := r.maxmindClient()
 	if err != nil {
-		return nil, tea.Stacktrace(err)
+		return nil, errs.Trace(err)
+	}
+
+	city, err := mc.GetContext(ctx, ip)
+	if err != nil {
+		return nil, errs.Trace(err)
 	}
 
 	loc := geonames.Location{
@@ -43,25 +53,167 @@ func (r *Radar) IP(addr string) (*geonames.Location, error) {
 
 // PSD primary subdivision lookup
 func (r *Radar) PSD(country country.Country, subdivision1 string) (*geonames.Location, error) {
-	return r.geonames.Get(geonames.Primary(country, subdivision1))
+	return r.PSDContext(context.Background(), country, subdivision1)
+}
+
+// PSDContext primary subdivision lookup, honoring cancellation of the context
+func (r *Radar) PSDContext(ctx context.Context, country country.Country, subdivision1 string) (*geonames.Location, error) {
+	return r.get(ctx, geonames.Primary(country, subdivision1))
 }
 
 // City lookup
 func (r *Radar) City(country country.Country, subdivision1, city string) (*geonames.Location, error) {
-	return r.geonames.Get(geonames.City(country, subdivision1, city))
+	return r.CityContext(context.Background(), country, subdivision1, city)
+}
+
+// CityContext city lookup, honoring cancellation of the context
+func (r *Radar) CityContext(ctx context.Context, country country.Country, subdivision1, city string) (*geonames.Location, error) {
+	return r.get(ctx, geonames.City(country, subdivision1, city))
 }
 
 // Postal lookup
 func (r *Radar) Postal(country country.Country, postal string) (*geonames.Location, error) {
-	return r.geonames.Get(geonames.PostalCode(country, postal))
+	return r.PostalContext(context.Background(), country, postal)
+}
+
+// PostalContext postal lookup, honoring cancellation of the context
+func (r *Radar) PostalContext(ctx context.Context, country country.Country, postal string) (*geonames.Location, error) {
+	return r.get(ctx, geonames.PostalCode(country, postal))
 }
 
 // SSD secondary division lookup
 func (r *Radar) SSD(country country.Country, subdivision1, subdivision2 string) (*geonames.Location, error) {
-	return r.geonames.Get(geonames.Secondary(country, subdivision1, subdivision2))
+	return r.SSDContext(context.Background(), country, subdivision1, subdivision2)
+}
+
+// SSDContext secondary division lookup, honoring cancellation of the context
+func (r *Radar) SSDContext(ctx context.Context, country country.Country, subdivision1, subdivision2 string) (*geonames.Location, error) {
+	return r.get(ctx, geonames.Secondary(country, subdivision1, subdivision2))
 }
 
 // Country lookup
 func (r *Radar) Country(country country.Country) (*geonames.Location, error) {
-	return r.geonames.Get(geonames.Country(country))
+	return r.CountryContext(context.Background(), country)
+}
+
+// CountryContext country lookup, honoring cancellation of the context
+func (r *Radar) CountryContext(ctx context.Context, country country.Country) (*geonames.Location, error) {
+	return r.get(ctx, geonames.Country(country))
+}
+
+// Reverse postal code lookup for the closest location to a coordinate
+func (r *Radar) Reverse(latitude, longitude float64) (*geonames.Location, error) {
+	return r.ReverseContext(context.Background(), latitude, longitude)
+}
+
+// ReverseContext reverse postal code lookup, honoring cancellation of the context
+func (r *Radar) ReverseContext(ctx context.Context, latitude, longitude float64) (*geonames.Location, error) {
+	gc, err := r.geonamesClient()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return gc.ReverseContext(ctx, latitude, longitude)
+}
+
+// Within postal code lookup for all locations within a radius (in km) of a coordinate
+func (r *Radar) Within(center geonames.Coordinate, radiusKm float64, opts ...geonames.SearchOption) ([]geonames.Neighbor, error) {
+	return r.WithinContext(context.Background(), center, radiusKm, opts...)
+}
+
+// WithinContext postal code lookup within a radius of a coordinate, honoring cancellation of the context
+func (r *Radar) WithinContext(ctx context.Context, center geonames.Coordinate, radiusKm float64, opts ...geonames.SearchOption) ([]geonames.Neighbor, error) {
+	gc, err := r.geonamesClient()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return gc.WithinContext(ctx, center, radiusKm, opts...)
+}
+
+// WithinPostal postal code lookup for all locations within a radius (in km) of a postal code
+func (r *Radar) WithinPostal(country country.Country, postal string, radiusKm float64, opts ...geonames.SearchOption) ([]geonames.Neighbor, error) {
+	return r.WithinPostalContext(context.Background(), country, postal, radiusKm, opts...)
+}
+
+// WithinPostalContext postal code lookup within a radius of a postal code, honoring cancellation of the context
+func (r *Radar) WithinPostalContext(ctx context.Context, country country.Country, postal string, radiusKm float64, opts ...geonames.SearchOption) ([]geonames.Neighbor, error) {
+	loc, err := r.PostalContext(ctx, country, postal)
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return r.WithinContext(ctx, loc.Center(), radiusKm, opts...)
+}
+
+// Nearest postal code lookup for the k closest locations to a coordinate
+func (r *Radar) Nearest(center geonames.Coordinate, k int, opts ...geonames.SearchOption) ([]geonames.Neighbor, error) {
+	return r.NearestContext(context.Background(), center, k, opts...)
+}
+
+// NearestContext postal code lookup for the k closest locations, honoring cancellation of the context
+func (r *Radar) NearestContext(ctx context.Context, center geonames.Coordinate, k int, opts ...geonames.SearchOption) ([]geonames.Neighbor, error) {
+	gc, err := r.geonamesClient()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return gc.NearestContext(ctx, center, k, opts...)
+}
+
+// Distance between the centers of two locations
+func (r *Radar) Distance(a, b geonames.LocationId, opts ...geonames.DistanceOption) (*geonames.Distance, error) {
+	return r.DistanceContext(context.Background(), a, b, opts...)
+}
+
+// DistanceContext distance between the centers of two locations, honoring cancellation of the context
+func (r *Radar) DistanceContext(ctx context.Context, a, b geonames.LocationId, opts ...geonames.DistanceOption) (*geonames.Distance, error) {
+	gc, err := r.geonamesClient()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	locations, err := gc.GetAllContext(ctx, a, b)
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	distance := geonames.Measure(locations[0].Center(), locations[1].Center(), opts...)
+	return &distance, nil
+}
+
+// Matrix of distances between the centers of every origin and every destination
+// each distinct location is resolved once, within a single transaction
+func (r *Radar) Matrix(origins, destinations []geonames.LocationId, opts ...geonames.DistanceOption) ([][]geonames.Distance, error) {
+	return r.MatrixContext(context.Background(), origins, destinations, opts...)
+}
+
+// MatrixContext matrix of distances between origins and destinations, honoring cancellation of the context
+func (r *Radar) MatrixContext(ctx context.Context, origins, destinations []geonames.LocationId, opts ...geonames.DistanceOption) ([][]geonames.Distance, error) {
+	gc, err := r.geonamesClient()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	locations, err := gc.GetAllContext(ctx, append(append([]geonames.LocationId{}, origins...), destinations...)...)
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	centers := make([]geonames.Coordinate, len(locations))
+	for i, location := range locations {
+		centers[i] = location.Center()
+	}
+
+	return geonames.Matrix(centers[:len(origins)], centers[len(origins):], opts...), nil
+}
+
+// get a location by id
+func (r *Radar) get(ctx context.Context, id geonames.LocationId) (*geonames.Location, error) {
+	gc, err := r.geonamesClient()
+	if err != nil {
+		return nil, errs.Trace(err)
+	}
+
+	return gc.GetContext(ctx, id)
 }
@@ -0,0 +1,132 @@
+package way
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/client"
+)
+
+// configureHTTP builds the http client for downloads from the transport and proxy options
+// if the proxy can not be applied, every download fails rather than bypassing it
+func (r *Radar) configureHTTP() {
+	if r.transport == nil && r.proxy == nil {
+		return
+	}
+
+	var hc http.Client
+	if r.httpClient != nil {
+		hc = *r.httpClient
+	}
+
+	if r.transport != nil {
+		hc.Transport = r.transport
+	}
+
+	if r.proxy != nil {
+		base, ok := hc.Transport.(*http.Transport)
+		switch {
+		case ok:
+		case hc.Transport == nil:
+			base = http.DefaultTransport.(*http.Transport)
+		default:
+			hc.Transport = misconfigured{err: tea.Errf("proxy can not be set on a %T transport", hc.Transport)}
+			r.httpClient = &hc
+			return
+		}
+
+		transport := base.Clone()
+		transport.Proxy = r.proxy
+		hc.Transport = transport
+	}
+
+	r.httpClient = &hc
+}
+
+// misconfigured is a transport failing every request with a configuration error
+type misconfigured struct {
+	err error
+}
+
+func (t misconfigured) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// clientOptions are the request options for downloads from http(s) locations
+func (r *Radar) clientOptions() []client.Option {
+	opts := []client.Option{client.Retries(r.retries), client.Backoff(r.backoff, r.maxBackoff)}
+	if r.httpClient != nil {
+		opts = append(opts, client.HTTPClient(r.httpClient))
+	}
+
+	if r.userAgent != "" {
+		opts = append(opts, client.Header("User-Agent", r.userAgent))
+	}
+
+	for key, values := range r.header {
+		for _, value := range values {
+			opts = append(opts, client.Header(key, value))
+		}
+	}
+
+	return opts
+}
+
+// HTTPClient sets a custom http client for downloads
+func HTTPClient(o *http.Client) RadarOption {
+	return func(r *Radar) {
+		r.httpClient = o
+	}
+}
+
+// Transport sets a custom transport for downloads
+func Transport(o http.RoundTripper) RadarOption {
+	return func(r *Radar) {
+		r.transport = o
+	}
+}
+
+// Proxy sets a custom proxy for downloads (e.g., http.ProxyURL with the credentials of an authenticated proxy)
+// it is applied to the default transport or a custom *http.Transport, downloads fail with any other transport
+func Proxy(o func(*http.Request) (*url.URL, error)) RadarOption {
+	return func(r *Radar) {
+		r.proxy = o
+	}
+}
+
+// Retries sets the maximum number of retries of a download after a transient failure
+// only failures before the response body is read are retried, a body interrupted midway fails the refresh from that mirror
+func Retries(n int) RadarOption {
+	return func(r *Radar) {
+		r.retries = n
+	}
+}
+
+// Backoff sets the wait time before the first retry of a download, which doubles (with jitter) for each retry up to the maximum
+func Backoff(initial, max time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.backoff = initial
+		r.maxBackoff = max
+	}
+}
+
+// UserAgent sets a custom user agent for downloads
+func UserAgent(o string) RadarOption {
+	return func(r *Radar) {
+		r.userAgent = o
+	}
+}
+
+// Header adds a header to downloads
+func Header(key, value string) RadarOption {
+	return func(r *Radar) {
+		if r.header == nil {
+			r.header = make(http.Header)
+		}
+
+		r.header.Add(key, value)
+	}
+}
@@ -0,0 +1,155 @@
+package way
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/source"
+)
+
+// Mirror is a location to refresh a dataset from
+type Mirror struct {
+	// Location is a http://, https:// or file:// url (ignored if Source is set)
+	Location string
+
+	// Source is a custom source
+	Source source.Source
+
+	// Timeout for refreshing from the mirror (zero to only be limited by the refresh timeout)
+	Timeout time.Duration
+}
+
+// maxmindHost is the host of maxmind downloads, the only one maxmind accounts are sent to
+const maxmindHost = "download.maxmind.com"
+
+// origin is a resolved mirror
+type origin struct {
+	src     source.Source
+	timeout time.Duration
+}
+
+// resolve mirrors to origins, replacing any license key placeholder in their locations
+// the account (if any) authenticates requests to the maxmind download host only
+func (r *Radar) resolve(mirrors []Mirror, account, key string) []origin {
+	origins := make([]origin, len(mirrors))
+	for i, m := range mirrors {
+		src := m.Source
+		if src == nil {
+			opts := r.clientOptions()
+			if u, err := url.Parse(m.Location); err == nil && account != "" && u.Hostname() == maxmindHost {
+				opts = append(opts, client.BasicAuth(account, key))
+			}
+
+			src = source.URL(strings.Replace(m.Location, "YOUR_LICENSE_KEY", key, 1), opts...)
+		}
+
+		origins[i] = origin{src: src, timeout: m.Timeout}
+	}
+
+	return origins
+}
+
+// configureOrigins resolves the sources to refresh each dataset from, once the http client has been configured
+func (r *Radar) configureOrigins() {
+	r.geonamesFeed.origins = r.geonamesOrigins()
+	r.maxmindFeed.origins = r.maxmindOrigins()
+}
+
+// geonamesOrigins are the sources to refresh geonames db from, in order
+func (r *Radar) geonamesOrigins() []origin {
+	mirrors := r.geonamesMirrors
+	if len(mirrors) == 0 {
+		mirrors = []Mirror{{Location: r.geonamesLocation, Source: r.geonamesSource}}
+	}
+
+	return r.resolve(mirrors, "", "")
+}
+
+// maxmindOrigins are the sources to refresh maxmind db from, in order (empty if maxmind is not configured)
+func (r *Radar) maxmindOrigins() []origin {
+	mirrors := r.maxmindMirrors
+	if len(mirrors) == 0 {
+		switch {
+		case r.maxmindSource != nil, r.maxmindLocation != DefaultMaxmindLocation:
+			mirrors = []Mirror{{Location: r.maxmindLocation, Source: r.maxmindSource}}
+		case r.maxmindAccount != "":
+			mirrors = []Mirror{{Location: fmt.Sprintf(MaxmindDownloadLocation, url.PathEscape(r.maxmindEdition))}}
+		case r.maxmindKey != "":
+			mirrors = []Mirror{{Location: strings.Replace(DefaultMaxmindLocation, DefaultMaxmindEdition, url.QueryEscape(r.maxmindEdition), 1)}}
+		default:
+			return nil
+		}
+	}
+
+	return r.resolve(mirrors, r.maxmindAccount, r.maxmindKey)
+}
+
+// geonamesOrigin is the first source to refresh geonames db from
+func (r *Radar) geonamesOrigin() source.Source {
+	return r.geonamesFeed.origin()
+}
+
+// maxmindOrigin is the first source to refresh maxmind db from (nil if maxmind is not configured)
+func (r *Radar) maxmindOrigin() source.Source {
+	return r.maxmindFeed.origin()
+}
+
+// origin is the first source to refresh the feed from (nil if there is none)
+func (f *feed) origin() source.Source {
+	if len(f.origins) == 0 {
+		return nil
+	}
+
+	return f.origins[0].src
+}
+
+// failover refreshes a dataset from each origin in turn until one succeeds (or finds the dataset unchanged)
+// each failed origin but the last is reported as a failover, the last is returned along with its error
+func (r *Radar) failover(ctx context.Context, dataset Dataset, origins []origin, fn func(ctx context.Context, src source.Source) error) (string, error) {
+	var name string
+	var err error
+	for i, o := range origins {
+		name = describe(o.src)
+		err = o.refresh(ctx, fn)
+		if err == nil || tea.IsError(err, client.ErrNotModified) || ctx.Err() != nil || i == len(origins)-1 {
+			break
+		}
+
+		r.publish(RefreshError{Source: dataset, Origin: name, Failover: true, Err: err})
+	}
+
+	return name, err
+}
+
+// refresh from the origin within its timeout
+func (o origin) refresh(ctx context.Context, fn func(ctx context.Context, src source.Source) error) error {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	return fn(ctx, o.src)
+}
+
+// GeonamesMirrors sets an ordered list of mirrors to refresh geonames db from
+// mirrors are tried in turn until one succeeds, and each failover is reported as a RefreshError
+func GeonamesMirrors(o ...Mirror) RadarOption {
+	return func(r *Radar) {
+		r.geonamesMirrors = o
+	}
+}
+
+// MaxmindMirrors sets an ordered list of mirrors to refresh maxmind db from
+// mirrors are tried in turn until one succeeds, and each failover is reported as a RefreshError
+func MaxmindMirrors(o ...Mirror) RadarOption {
+	return func(r *Radar) {
+		r.maxmindMirrors = o
+	}
+}
@@ -2,50 +2,344 @@ package way
 
 import (
 	"context"
-	"strings"
+	"io"
 	"sync"
+	"time"
 
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/client"
 	"github.com/pghq/go-way/geonames"
+	"github.com/pghq/go-way/internal/errs"
 	"github.com/pghq/go-way/maxmind"
+	"github.com/pghq/go-way/source"
 )
 
+// RefreshHandle is a refresh requested with RefreshAsync
+type RefreshHandle struct {
+	ctx  context.Context
+	runs []*refreshRun
+	done <-chan struct{}
+}
+
+// Done is closed once the refresh has finished
+func (h RefreshHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait for the refresh to finish
+// returns the first refresh error (a RefreshError if a dataset failed to refresh), or the context error if the caller gave up first
+func (h RefreshHandle) Wait() error {
+	select {
+	case <-h.done:
+		for _, run := range h.runs {
+			if run.err != nil {
+				return run.err
+			}
+		}
+
+		return nil
+	case <-h.ctx.Done():
+		return errs.Trace(h.ctx.Err())
+	}
+}
+
+// newHandle creates a handle that is done once every run has finished
+func newHandle(ctx context.Context, runs ...*refreshRun) RefreshHandle {
+	if len(runs) == 1 {
+		return RefreshHandle{ctx: ctx, runs: runs, done: runs[0].done}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, run := range runs {
+			<-run.done
+		}
+
+		close(done)
+	}()
+
+	return RefreshHandle{ctx: ctx, runs: runs, done: done}
+}
+
+// refreshRun is a refresh shared by every caller that requested it before it finished
+type refreshRun struct {
+	feed     *feed
+	ctx      context.Context
+	cancel   context.CancelFunc
+	started  bool
+	waiters  int
+	detached bool
+	done     chan struct{}
+	err      error
+	once     sync.Once
+}
+
+// finish the run with its result
+// only the first result counts, since a pending run may be finished by Close and its last caller at once
+func (run *refreshRun) finish(err error) {
+	run.once.Do(func() {
+		run.err = err
+		run.cancel()
+		close(run.done)
+	})
+}
+
+// feed is the refresh state of a dataset, which is refreshed independently of the others
+type feed struct {
+	dataset   Dataset
+	timeout   time.Duration
+	rule      string
+	origins   []origin
+	schedule  schedule
+	next      time.Time
+	run       *refreshRun
+	status    DatasetStatus
+	minCount  int
+	maxShrink float64
+	skip      client.Validator
+}
+
+// feeds to refresh (maxmind is omitted if it is not configured)
+func (r *Radar) feeds() []*feed {
+	if r.maxmindOrigin() == nil {
+		return []*feed{&r.geonamesFeed}
+	}
+
+	return []*feed{&r.geonamesFeed, &r.maxmindFeed}
+}
+
 // Refresh locations
-func (r *Radar) Refresh() {
-	wg := sync.WaitGroup{}
-	r.refreshes <- &wg
-	wg.Add(1)
-	wg.Wait()
+// returns the first refresh error (a RefreshError if a dataset failed to refresh)
+func (r *Radar) Refresh() error {
+	return r.RefreshAsync(context.Background()).Wait()
 }
 
-// refreshJob process pending refreshes / waits
-func (r *Radar) refreshJob() {
+// RefreshGeonames refreshes the geonames db without refreshing the maxmind db
+func (r *Radar) RefreshGeonames() error {
+	return r.RefreshGeonamesAsync(context.Background()).Wait()
+}
+
+// RefreshMaxmind refreshes the maxmind db without refreshing the geonames db
+// does nothing if maxmind is not configured
+func (r *Radar) RefreshMaxmind() error {
+	return r.RefreshMaxmindAsync(context.Background()).Wait()
+}
+
+// RefreshAsync requests a refresh of the locations without waiting for it
+// each dataset is refreshed independently, so a failure of one does not hold back the other
+// concurrent requests are coalesced into a single refresh, which is canceled once every requesting context is done
+func (r *Radar) RefreshAsync(ctx context.Context) RefreshHandle {
+	return r.request(ctx, r.feeds()...)
+}
+
+// RefreshGeonamesAsync requests a refresh of the geonames db without waiting for it
+func (r *Radar) RefreshGeonamesAsync(ctx context.Context) RefreshHandle {
+	return r.request(ctx, &r.geonamesFeed)
+}
+
+// RefreshMaxmindAsync requests a refresh of the maxmind db without waiting for it
+// does nothing if maxmind is not configured
+func (r *Radar) RefreshMaxmindAsync(ctx context.Context) RefreshHandle {
+	if r.maxmindOrigin() == nil {
+		return r.request(ctx)
+	}
+
+	return r.request(ctx, &r.maxmindFeed)
+}
+
+// request a refresh of the feeds, joining any refresh of a feed that has not finished yet
+func (r *Radar) request(ctx context.Context, feeds ...*feed) RefreshHandle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isClosed() {
+		run := refreshRun{cancel: func() {}, done: make(chan struct{})}
+		run.finish(errs.New(ErrClosed, "radar closed"))
+		return newHandle(ctx, &run)
+	}
+
+	runs := make([]*refreshRun, len(feeds))
+	for i, f := range feeds {
+		run := f.run
+		if run == nil {
+			run = &refreshRun{feed: f, done: make(chan struct{})}
+			run.ctx, run.cancel = context.WithCancel(r.ctx)
+			f.run = run
+		}
+
+		run.waiters++
+		if ctx.Done() == nil {
+			run.detached = true
+		} else {
+			go r.abandon(ctx, run)
+		}
+
+		runs[i] = run
+	}
+
+	return newHandle(ctx, runs...)
+}
+
+// abandon a run once the requesting context is done, canceling it if nobody else is waiting
+// a canceled run is detached from its feed so that later requests start a new one
+func (r *Radar) abandon(ctx context.Context, run *refreshRun) {
 	select {
-	case wg := <-r.refreshes:
-		defer wg.Done()
+	case <-ctx.Done():
+	case <-run.done:
+		return
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), r.refreshTimeout)
-		defer cancel()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if run.waiters--; run.waiters > 0 || run.detached {
+		return
+	}
+
+	if run.feed.run == run {
+		run.feed.run = nil
+	}
+
+	run.cancel()
+	if !run.started {
+		run.finish(errs.Trace(ctx.Err()))
+	}
+}
+
+// refreshJob starts pending refreshes
+func (r *Radar) refreshJob() {
+	for _, f := range r.feeds() {
+		r.mu.Lock()
+		run := f.run
+		if run == nil || run.started {
+			r.mu.Unlock()
+			continue
+		}
 
-		gc, err := geonames.NewClient(ctx, r.geonamesLocation, r.countries...)
+		if r.isClosed() {
+			f.run = nil
+			r.mu.Unlock()
+			run.finish(errs.New(ErrClosed, "radar closed"))
+			continue
+		}
+
+		run.started = true
+		r.running.Add(1)
+		r.mu.Unlock()
+
+		go r.refresh(f, run)
+	}
+}
+
+// refresh a feed and finish its run
+func (r *Radar) refresh(f *feed, run *refreshRun) {
+	defer r.running.Done()
+
+	ctx, cancel := context.WithTimeout(run.ctx, f.timeout)
+	defer cancel()
+
+	var err error
+	switch f.dataset {
+	case MaxmindDataset:
+		err = r.reloadMaxmind(ctx)
+	default:
+		err = r.reloadGeonames(ctx)
+	}
+
+	r.markReady()
+	r.mu.Lock()
+	if f.run == run {
+		f.run = nil
+	}
+	r.mu.Unlock()
+	run.finish(err)
+}
+
+// reloadGeonames swaps in the geonames db if it has changed
+func (r *Radar) reloadGeonames(ctx context.Context) error {
+	r.start(GeonamesDataset)
+	base := r.geonamesBase()
+	var gc *geonames.Client
+	origin, err := r.failover(ctx, GeonamesDataset, r.geonamesFeed.origins, func(ctx context.Context, src source.Source) error {
+		c, err := base.Refresh(ctx, src, r.countries...)
 		if err != nil {
-			r.sendError(err)
-			return
+			return err
 		}
 
-		r.geonames = gc
-		if r.maxmindLocation != DefaultMaxmindLocation || r.maxmindKey != "" {
-			mc, err := maxmind.NewClient(ctx, strings.Replace(r.maxmindLocation, "YOUR_LICENSE_KEY", r.maxmindKey, 1))
-			if err != nil {
-				r.sendError(err)
-				return
-			}
+		if err := r.validateGeonames(c); err != nil {
+			_ = c.Close()
+			return errs.AtStage(errs.StageValidate, err)
+		}
 
-			if r.maxmind != nil {
-				_ = r.maxmind.Close()
-			}
+		gc = c
+		return nil
+	})
+
+	r.track(&r.geonamesFeed.status, err)
+	switch {
+	case tea.IsError(err, client.ErrNotModified):
+	case err != nil:
+		return r.fail(GeonamesDataset, origin, err)
+	default:
+		event := RefreshSuccess{Source: GeonamesDataset, NewCount: gc.LocationCount, NewVersion: gc.Validator}
+		if old := r.replaceGeonames(gc); old != nil {
+			event.OldCount, event.OldVersion = old.LocationCount, old.Validator
+		}
+
+		r.succeed(event)
+	}
+
+	return nil
+}
 
-			r.maxmind = mc
+// reloadMaxmind swaps in the maxmind db if it has changed
+func (r *Radar) reloadMaxmind(ctx context.Context) error {
+	r.start(MaxmindDataset)
+	base := r.maxmindBase()
+	var mc *maxmind.Client
+	origin, err := r.failover(ctx, MaxmindDataset, r.maxmindFeed.origins, func(ctx context.Context, src source.Source) error {
+		c, err := base.Refresh(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		if err := r.validateMaxmind(c); err != nil {
+			_ = c.Close()
+			return errs.AtStage(errs.StageValidate, err)
 		}
+
+		mc = c
+		return nil
+	})
+
+	r.track(&r.maxmindFeed.status, err)
+	switch {
+	case tea.IsError(err, client.ErrNotModified):
+	case err != nil:
+		return r.fail(MaxmindDataset, origin, err)
 	default:
+		event := RefreshSuccess{Source: MaxmindDataset, NewCount: mc.IPCount, NewVersion: mc.Validator}
+		if old := r.replaceMaxmind(mc); old != nil {
+			event.OldCount, event.OldVersion = old.IPCount, old.Validator
+		}
+
+		r.succeed(event)
+	}
+
+	return nil
+}
+
+// drain closes a replaced client once in-flight lookups have had time to finish
+// must be called with the lock held, clients still draining are closed by Close
+func (r *Radar) drain(c io.Closer) {
+	if r.draining == nil {
+		r.draining = make(map[io.Closer]*time.Timer)
 	}
+
+	r.draining[c] = time.AfterFunc(r.drainTimeout, func() {
+		r.mu.Lock()
+		delete(r.draining, c)
+		r.mu.Unlock()
+		_ = c.Close()
+	})
 }
@@ -0,0 +1,116 @@
+package way
+
+import (
+	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/geonames"
+	"github.com/pghq/go-way/internal/errs"
+	"github.com/pghq/go-way/maxmind"
+)
+
+// Rollback restores the previous version of each dataset replaced by a refresh
+// the rolled back versions are closed once drained, and refreshes skip them until their source changes
+// returns ErrNoPrevious if no dataset has a previous version
+func (r *Radar) Rollback() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isClosed() {
+		return errs.New(ErrClosed, "radar closed")
+	}
+
+	if r.previousGeonames == nil && r.previousMaxmind == nil {
+		return errs.New(ErrNoPrevious, "no previous version to roll back to")
+	}
+
+	if r.previousGeonames != nil {
+		current := r.geonames.Swap(r.previousGeonames).(*geonames.Client)
+		r.previousGeonames = nil
+		r.geonamesFeed.skip = current.Validator
+		r.drain(current)
+	}
+
+	if r.previousMaxmind != nil {
+		current := r.maxmind.Swap(r.previousMaxmind).(*maxmind.Client)
+		r.previousMaxmind = nil
+		r.maxmindFeed.skip = current.Validator
+		r.drain(current)
+	}
+
+	return nil
+}
+
+// DisableRollback closes replaced datasets once drained instead of keeping them for Rollback
+// keeping the previous version roughly doubles the memory and disk used by each dataset
+func DisableRollback() RadarOption {
+	return func(r *Radar) {
+		r.disableRollback = true
+	}
+}
+
+// replaceGeonames swaps in a refreshed geonames client, keeping the replaced one for rollback
+// the client it replaces for rollback (two versions back) is closed once drained
+func (r *Radar) replaceGeonames(gc *geonames.Client) *geonames.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, _ := r.geonames.Swap(gc).(*geonames.Client)
+	if r.previousGeonames != nil {
+		r.drain(r.previousGeonames)
+	}
+
+	r.previousGeonames = old
+	if r.disableRollback && old != nil {
+		r.drain(old)
+		r.previousGeonames = nil
+	}
+
+	r.geonamesFeed.skip = client.Validator{}
+	return old
+}
+
+// replaceMaxmind swaps in a refreshed maxmind client, keeping the replaced one for rollback
+// the client it replaces for rollback (two versions back) is closed once drained
+func (r *Radar) replaceMaxmind(mc *maxmind.Client) *maxmind.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, _ := r.maxmind.Swap(mc).(*maxmind.Client)
+	if r.previousMaxmind != nil {
+		r.drain(r.previousMaxmind)
+	}
+
+	r.previousMaxmind = old
+	if r.disableRollback && old != nil {
+		r.drain(old)
+		r.previousMaxmind = nil
+	}
+
+	r.maxmindFeed.skip = client.Validator{}
+	return old
+}
+
+// geonamesBase is the geonames client to refresh from
+// after a rollback, it is a stand-in for the rolled back version so that refreshes skip it until the source changes
+func (r *Radar) geonamesBase() *geonames.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.geonamesFeed.skip.IsZero() {
+		return &geonames.Client{Validator: r.geonamesFeed.skip}
+	}
+
+	return r.loadGeonames()
+}
+
+// maxmindBase is the maxmind client to refresh from
+// after a rollback, it is a stand-in for the rolled back version so that refreshes skip it until the source changes
+func (r *Radar) maxmindBase() *maxmind.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.maxmindFeed.skip.IsZero() {
+		return &maxmind.Client{Validator: r.maxmindFeed.skip}
+	}
+
+	return r.loadMaxmind()
+}
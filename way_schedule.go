@@ -0,0 +1,182 @@
+package way
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pghq/go-tea"
+	"github.com/teambition/rrule-go"
+
+	"github.com/pghq/go-way/internal/errs"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// schedule determines when refreshes occur
+type schedule interface {
+	// Next refresh time after t (zero if there are no more refreshes)
+	Next(t time.Time) time.Time
+}
+
+// interval is a schedule with a fixed period between refreshes
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// recurrence is a schedule following an RFC 5545 recurrence rule
+type recurrence struct {
+	rule *rrule.RRule
+}
+
+func (r recurrence) Next(t time.Time) time.Time {
+	return r.rule.After(t, false)
+}
+
+// newRecurrence creates a recurrence schedule from a rule, starting now if the rule has no DTSTART
+func newRecurrence(rule string, now time.Time) (*recurrence, error) {
+	opt, err := rrule.StrToROption(rule)
+	if err != nil {
+		return nil, tea.Stacktrace(err)
+	}
+
+	if opt.Dtstart.IsZero() {
+		opt.Dtstart = now
+	}
+
+	rr, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return nil, tea.Stacktrace(err)
+	}
+
+	return &recurrence{rule: rr}, nil
+}
+
+// configureSchedule builds the schedule of a feed from its options
+// a feed with a bad schedule is not refreshed in the background
+func (r *Radar) configureSchedule(f *feed) error {
+	if i, ok := f.schedule.(interval); ok && i <= 0 {
+		f.schedule = nil
+		return errs.New(ErrInvalidInput, "refresh interval must be positive, got ", time.Duration(i))
+	}
+
+	if f.rule == "" {
+		return nil
+	}
+
+	rule, err := newRecurrence(f.rule, r.clock.Now())
+	if err != nil {
+		return errs.New(ErrInvalidInput, err)
+	}
+
+	f.schedule = rule
+	return nil
+}
+
+// scheduleNext sets the time of the next scheduled refresh of a feed
+func (r *Radar) scheduleNext(f *feed, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f.schedule == nil {
+		return
+	}
+
+	f.next = f.schedule.Next(now)
+	if !f.next.IsZero() && r.refreshJitter > 0 {
+		f.next = f.next.Add(time.Duration(rand.Int63n(int64(r.refreshJitter))))
+	}
+}
+
+// scheduleJob triggers scheduled refreshes
+func (r *Radar) scheduleJob() {
+	for _, f := range r.feeds() {
+		r.mu.Lock()
+		now := r.clock.Now()
+		due := !f.next.IsZero() && !now.Before(f.next)
+		if due {
+			f.next = time.Time{}
+		}
+		r.mu.Unlock()
+
+		if !due {
+			continue
+		}
+
+		r.request(context.Background(), f)
+		r.scheduleNext(f, now)
+	}
+}
+
+// RefreshInterval sets a fixed period between background refreshes of every dataset
+// the period must be positive, otherwise it is reported as a RefreshError and background refreshes are disabled
+func RefreshInterval(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		GeonamesRefreshInterval(o)(r)
+		MaxmindRefreshInterval(o)(r)
+	}
+}
+
+// GeonamesRefreshInterval sets a fixed period between background refreshes of the geonames db (must be positive)
+func GeonamesRefreshInterval(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.geonamesFeed.schedule = interval(o)
+		r.geonamesFeed.rule = ""
+	}
+}
+
+// MaxmindRefreshInterval sets a fixed period between background refreshes of the maxmind db (must be positive)
+func MaxmindRefreshInterval(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.maxmindFeed.schedule = interval(o)
+		r.maxmindFeed.rule = ""
+	}
+}
+
+// RefreshSchedule sets an RFC 5545 recurrence rule for background refreshes of every dataset (e.g., "FREQ=DAILY;BYHOUR=3")
+func RefreshSchedule(o string) RadarOption {
+	return func(r *Radar) {
+		GeonamesRefreshSchedule(o)(r)
+		MaxmindRefreshSchedule(o)(r)
+	}
+}
+
+// GeonamesRefreshSchedule sets an RFC 5545 recurrence rule for background refreshes of the geonames db
+func GeonamesRefreshSchedule(o string) RadarOption {
+	return func(r *Radar) {
+		r.geonamesFeed.schedule = nil
+		r.geonamesFeed.rule = o
+	}
+}
+
+// MaxmindRefreshSchedule sets an RFC 5545 recurrence rule for background refreshes of the maxmind db (e.g., "FREQ=WEEKLY;BYDAY=TU,FR")
+func MaxmindRefreshSchedule(o string) RadarOption {
+	return func(r *Radar) {
+		r.maxmindFeed.schedule = nil
+		r.maxmindFeed.rule = o
+	}
+}
+
+// RefreshJitter sets the maximum random delay added to each scheduled refresh
+func RefreshJitter(o time.Duration) RadarOption {
+	return func(r *Radar) {
+		r.refreshJitter = o
+	}
+}
+
+// RefreshClock sets a custom clock for scheduling refreshes
+func RefreshClock(o Clock) RadarOption {
+	return func(r *Radar) {
+		r.clock = o
+	}
+}
@@ -0,0 +1,114 @@
+package way
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pghq/go-tea"
+
+	"github.com/pghq/go-way/client"
+	"github.com/pghq/go-way/source"
+)
+
+// Status of the radar datasets
+type Status struct {
+	Geonames DatasetStatus
+	Maxmind  DatasetStatus
+}
+
+// DatasetStatus is the refresh status and metadata of a dataset
+type DatasetStatus struct {
+	// Source the dataset is refreshed from, the first of its mirrors if it has several (secrets are redacted)
+	Source string
+
+	// Validator (ETag and Last-Modified) of the active dataset
+	Validator client.Validator
+
+	// Previous is the validator of the previous dataset kept for Rollback (zero if there is none)
+	Previous client.Validator
+
+	// RolledBack reports whether the active dataset was restored by Rollback
+	RolledBack bool
+
+	// LastSuccess is the time of the last successful refresh, including refreshes finding the dataset unchanged
+	LastSuccess time.Time
+
+	// LastFailure is the time of the last failed refresh
+	LastFailure time.Time
+
+	// LastError is the error of the last failed refresh
+	LastError error
+
+	// Count is the number of locations (GeoNames) or network nodes (MaxMind) loaded
+	Count int
+
+	// BuildEpoch is when the loaded database was built (MaxMind only)
+	BuildEpoch time.Time
+
+	// DatabaseType is the edition of the loaded database, e.g., GeoLite2-City (MaxMind only)
+	DatabaseType string
+
+	// Size is the approximate memory used by the loaded dataset in bytes
+	Size int64
+}
+
+// Status reports the refresh status and metadata of each dataset
+func (r *Radar) Status() Status {
+	r.mu.Lock()
+	status := Status{Geonames: r.geonamesFeed.status, Maxmind: r.maxmindFeed.status}
+	status.Geonames.RolledBack = !r.geonamesFeed.skip.IsZero()
+	status.Maxmind.RolledBack = !r.maxmindFeed.skip.IsZero()
+	if r.previousGeonames != nil {
+		status.Geonames.Previous = r.previousGeonames.Validator
+	}
+
+	if r.previousMaxmind != nil {
+		status.Maxmind.Previous = r.previousMaxmind.Validator
+	}
+	r.mu.Unlock()
+
+	status.Geonames.Source = describe(r.geonamesOrigin())
+	if gc := r.loadGeonames(); gc != nil {
+		status.Geonames.Validator = gc.Validator
+		status.Geonames.Count = gc.LocationCount
+		status.Geonames.Size = gc.Size
+	}
+
+	if src := r.maxmindOrigin(); src != nil {
+		status.Maxmind.Source = describe(src)
+	}
+
+	if mc := r.loadMaxmind(); mc != nil {
+		status.Maxmind.Validator = mc.Validator
+		status.Maxmind.Count = mc.IPCount
+		status.Maxmind.BuildEpoch = mc.BuildEpoch
+		status.Maxmind.DatabaseType = mc.DatabaseType
+		status.Maxmind.Size = mc.Size
+	}
+
+	return status
+}
+
+// track the outcome of a dataset refresh
+func (r *Radar) track(status *DatasetStatus, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	if err != nil && !tea.IsError(err, client.ErrNotModified) {
+		status.LastFailure = now
+		status.LastError = err
+		return
+	}
+
+	status.LastSuccess = now
+}
+
+// describe a source without revealing its configuration
+func describe(src source.Source) string {
+	if s, ok := src.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return fmt.Sprintf("%T", src)
+}
@@ -1,16 +1,30 @@
 package way
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pghq/go-tea"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/pghq/go-way/client"
 	"github.com/pghq/go-way/country"
+	"github.com/pghq/go-way/geonames"
+	"github.com/pghq/go-way/source"
 )
 
 func TestMain(m *testing.M) {
@@ -51,10 +65,11 @@ func TestNew(t *testing.T) {
 	})
 
 	t.Run("can create new instance", func(t *testing.T) {
-		r := New(GeonamesLocation(s.URL), RefreshTimeout(time.Second), Countries("us"))
+		r := New(GeonamesLocation(s.URL), RefreshTimeout(time.Second), MaxmindRefreshTimeout(time.Minute), Countries("us"))
 		assert.Nil(t, r.Error())
 		assert.NotNil(t, r)
-		assert.Equal(t, r.refreshTimeout, time.Second)
+		assert.Equal(t, r.geonamesFeed.timeout, time.Second)
+		assert.Equal(t, r.maxmindFeed.timeout, time.Minute)
 		assert.Equal(t, r.geonamesLocation, s.URL)
 	})
 }
@@ -70,6 +85,27 @@ func TestRadar_Refresh(t *testing.T) {
 
 			r := New(GeonamesLocation(s.URL))
 			assert.NotNil(t, r.Error())
+
+			var e RefreshError
+			assert.True(t, errors.As(r.Refresh(), &e))
+			assert.Equal(t, GeonamesDataset, e.Source)
+			assert.Equal(t, StageDecompress, e.Stage)
+		})
+
+		t.Run("canceled by every caller", func(t *testing.T) {
+			s, hang := block("testdata/sample.zip")
+			r := New(GeonamesLocation(s.URL))
+			hang()
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			h := r.RefreshAsync(ctx)
+			assert.Eventually(t, func() bool { return atomic.LoadInt32(&s.hits) == 2 }, time.Second, time.Millisecond)
+			cancel()
+			<-h.Done()
+
+			var e RefreshError
+			assert.True(t, errors.As(h.runs[0].err, &e))
+			assert.Equal(t, StageDownload, e.Stage)
 		})
 	})
 
@@ -77,7 +113,159 @@ func TestRadar_Refresh(t *testing.T) {
 		s := serve("testdata/sample.zip")
 		mxm := serve("testdata/GeoLite2-City.tgz")
 		r := New(GeonamesLocation(s.URL), MaxmindLocation(mxm.URL))
+		assert.Nil(t, r.Refresh())
+	})
+
+	t.Run("coalesces concurrent refreshes", func(t *testing.T) {
+		s, hang := block("testdata/sample.zip")
+		r := New(GeonamesLocation(s.URL))
+		release := hang()
+
+		var handles []RefreshHandle
+		for i := 0; i < 3; i++ {
+			handles = append(handles, r.RefreshAsync(context.TODO()))
+		}
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		abandoned := r.RefreshAsync(ctx)
+		cancel()
+		assert.True(t, errors.Is(abandoned.Wait(), context.Canceled))
+
+		release()
+		for _, h := range handles {
+			assert.Nil(t, h.Wait())
+			assert.Same(t, handles[0].runs[0], h.runs[0])
+		}
+
+		assert.Same(t, handles[0].runs[0], abandoned.runs[0])
+		assert.Equal(t, int32(2), atomic.LoadInt32(&s.hits))
+	})
+
+	t.Run("refreshes datasets independently", func(t *testing.T) {
+		var hits int32
+		s := count(&hits, "testdata/sample.zip")
+		mxm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+
+		r := New(GeonamesLocation(s.URL), MaxmindLocation(mxm.URL))
+		gc := r.loadGeonames()
+		assert.NotNil(t, gc)
+
+		var e RefreshError
+		assert.True(t, errors.As(r.RefreshMaxmind(), &e))
+		assert.Equal(t, MaxmindDataset, e.Source)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+		assert.Nil(t, r.RefreshGeonames())
+		assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+		assert.True(t, errors.As(r.Refresh(), &e))
+		assert.Equal(t, MaxmindDataset, e.Source)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+		assert.Same(t, gc, r.loadGeonames())
+
+		r = New(GeonamesLocation(s.URL))
+		assert.Nil(t, r.RefreshMaxmind())
+	})
+
+	t.Run("does not join canceled refreshes", func(t *testing.T) {
+		g := make(gated)
+		r := New(GeonamesSource(g), AsyncStart())
+		g <- struct{}{}
+		assert.Eventually(t, func() bool {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.geonamesFeed.run == nil
+		}, time.Second, time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		a := r.RefreshAsync(ctx)
+		assert.Eventually(t, func() bool {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return a.runs[0].started
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		assert.Eventually(t, func() bool {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.geonamesFeed.run != a.runs[0]
+		}, time.Second, time.Millisecond)
+
+		b := r.RefreshAsync(context.Background())
+		assert.NotSame(t, a.runs[0], b.runs[0])
+		close(g)
+		assert.Nil(t, b.Wait())
+	})
+
+	t.Run("can refresh offline", func(t *testing.T) {
+		r := New(GeonamesLocation("file://testdata/sample.zip"), MaxmindSource(source.FS(os.DirFS("testdata"), "GeoLite2-City.tgz")))
+		assert.Nil(t, r.Error())
+		assert.NotNil(t, r.loadGeonames())
+		assert.NotNil(t, r.loadMaxmind())
+
+		r = New(GeonamesSource(source.File("testdata/sample.zip")))
+		assert.Nil(t, r.Error())
+		assert.NotNil(t, r.loadGeonames())
+		assert.Nil(t, r.loadMaxmind())
+	})
+
+	t.Run("skips unchanged exports", func(t *testing.T) {
+		var hits int32
+		s := count(&hits, "testdata/sample.zip")
+		mxm := serve("testdata/GeoLite2-City.tgz")
+		r := New(GeonamesLocation(s.URL), MaxmindLocation(mxm.URL))
+		gc, mc := r.loadGeonames(), r.loadMaxmind()
+
 		r.Refresh()
+		assert.Nil(t, r.Error())
+		assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+		assert.Same(t, gc, r.loadGeonames())
+		assert.Same(t, mc, r.loadMaxmind())
+	})
+
+	t.Run("can look up during refresh", func(t *testing.T) {
+		s := stream("testdata/sample.zip")
+		mxm := stream("testdata/GeoLite2-City.tgz")
+		r := New(GeonamesLocation(s.URL), MaxmindLocation(mxm.URL), DrainTimeout(time.Millisecond))
+		mc := r.loadMaxmind()
+
+		done := make(chan struct{})
+		wg := sync.WaitGroup{}
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					case <-time.After(time.Millisecond):
+					}
+
+					loc, err := r.Postal("US", "20017")
+					assert.Nil(t, err)
+					assert.NotNil(t, loc)
+
+					_, _ = r.IP("81.2.69.142")
+				}
+			}()
+		}
+
+		for i := 0; i < 2; i++ {
+			r.Refresh()
+			assert.Nil(t, r.Error())
+		}
+
+		close(done)
+		wg.Wait()
+		assert.NotSame(t, mc, r.loadMaxmind())
+
+		loc, err := r.IP("81.2.69.142")
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
 	})
 }
 
@@ -91,22 +279,45 @@ func TestRadar_Get(t *testing.T) {
 	t.Run("should notify on errors", func(t *testing.T) {
 		t.Run("cache miss", func(t *testing.T) {
 			_, err := r.Postal("US", "999999")
-			assert.NotNil(t, err)
+			assert.True(t, errors.Is(err, ErrNotFound))
 		})
 
 		t.Run("bad ip", func(t *testing.T) {
 			_, err := r.IP("bad")
-			assert.NotNil(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidInput))
 		})
 
 		t.Run("not found", func(t *testing.T) {
 			_, err := r.IP("192.168.1.1")
-			assert.NotNil(t, err)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+
+		t.Run("maxmind not configured", func(t *testing.T) {
+			r := New(GeonamesLocation(s.URL))
+			_, err := r.IP("81.2.69.142")
+			assert.True(t, errors.Is(err, ErrNotConfigured))
+		})
+
+		t.Run("canceled context", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+
+			_, err := r.PostalContext(ctx, "US", "20017")
+			assert.True(t, errors.Is(err, context.Canceled))
+
+			_, err = r.IPContext(ctx, "81.2.69.142")
+			assert.True(t, errors.Is(err, context.Canceled))
+
+			_, err = r.NearestContext(ctx, geonames.Coordinate{Latitude: 38.9367, Longitude: -76.994}, 1)
+			assert.True(t, errors.Is(err, context.Canceled))
 		})
 	})
 
 	t.Run("can retrieve location", func(t *testing.T) {
-		loc, err := r.Postal("US", "20017")
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+		defer cancel()
+
+		loc, err := r.PostalContext(ctx, "US", "20017")
 		assert.Nil(t, err)
 		assert.NotNil(t, loc)
 		assert.Equal(t, country.UnitedStatesAmerica, loc.Country)
@@ -175,8 +386,949 @@ func TestRadar_Envelope(t *testing.T) {
 	})
 }
 
+func TestRadar_Reverse(t *testing.T) {
+	t.Parallel()
+
+	s := serve("testdata/sample.zip")
+	r := New(GeonamesLocation(s.URL))
+
+	t.Run("not ready", func(t *testing.T) {
+		r := Radar{}
+		_, err := r.Reverse(38.9367, -76.994)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("can retrieve location", func(t *testing.T) {
+		loc, err := r.Reverse(38.9367, -76.994)
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
+		assert.Equal(t, country.UnitedStatesAmerica, loc.Country)
+		assert.Equal(t, "20017", loc.PostalCode)
+	})
+}
+
+func TestRadar_Within(t *testing.T) {
+	t.Parallel()
+
+	s := serve("testdata/sample.zip")
+	r := New(GeonamesLocation(s.URL))
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("not found postal", func(t *testing.T) {
+			_, err := r.WithinPostal("US", "999999", 10)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+	})
+
+	t.Run("can retrieve locations", func(t *testing.T) {
+		neighbors, err := r.Within(geonames.Coordinate{Latitude: 38.9367, Longitude: -76.994}, 3)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, neighbors)
+		assert.Equal(t, "20017", neighbors[0].PostalCode)
+
+		neighbors, err = r.WithinPostal("US", "20017", 3)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, neighbors)
+		assert.Equal(t, "20017", neighbors[0].PostalCode)
+		assert.Equal(t, 0.0, neighbors[0].Distance)
+	})
+}
+
+func TestRadar_Nearest(t *testing.T) {
+	t.Parallel()
+
+	s := serve("testdata/sample.zip")
+	r := New(GeonamesLocation(s.URL))
+
+	t.Run("can retrieve locations", func(t *testing.T) {
+		neighbors, err := r.Nearest(geonames.Coordinate{Latitude: 38.9367, Longitude: -76.994}, 2, geonames.InCountry(country.UnitedStatesAmerica))
+		assert.Nil(t, err)
+		assert.Len(t, neighbors, 2)
+		assert.Equal(t, "20017", neighbors[0].PostalCode)
+	})
+}
+
+func TestRadar_Distance(t *testing.T) {
+	t.Parallel()
+
+	s := serve("testdata/sample.zip")
+	r := New(GeonamesLocation(s.URL))
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("bad from", func(t *testing.T) {
+			_, err := r.Distance(geonames.PostalCode("US", "999999"), geonames.PostalCode("US", "20017"))
+			assert.NotNil(t, err)
+		})
+
+		t.Run("bad to", func(t *testing.T) {
+			_, err := r.Distance(geonames.PostalCode("US", "20017"), geonames.PostalCode("US", "999999"))
+			assert.NotNil(t, err)
+		})
+	})
+
+	t.Run("can measure distance", func(t *testing.T) {
+		d, err := r.Distance(geonames.PostalCode("US", "20017"), geonames.City("US", "ny", "brooklyn"))
+		assert.Nil(t, err)
+		assert.NotNil(t, d)
+		assert.InDelta(t, 325, d.Kilometers, 10)
+		assert.InDelta(t, 202, d.Miles, 10)
+		assert.InDelta(t, 50, d.Bearing, 10)
+
+		e, err := r.Distance(geonames.PostalCode("US", "20017"), geonames.City("US", "ny", "brooklyn"), geonames.Ellipsoidal())
+		assert.Nil(t, err)
+		assert.NotNil(t, e)
+		assert.InDelta(t, d.Kilometers, e.Kilometers, 2)
+	})
+}
+
+func TestRadar_Matrix(t *testing.T) {
+	t.Parallel()
+
+	s := serve("testdata/sample.zip")
+	r := New(GeonamesLocation(s.URL))
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("not found", func(t *testing.T) {
+			_, err := r.Matrix([]geonames.LocationId{geonames.PostalCode("US", "999999")}, []geonames.LocationId{geonames.PostalCode("US", "20017")})
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+	})
+
+	t.Run("can measure distances", func(t *testing.T) {
+		origins := []geonames.LocationId{geonames.PostalCode("US", "20017"), geonames.City("US", "ny", "brooklyn")}
+		destinations := []geonames.LocationId{geonames.City("US", "ny", "brooklyn"), geonames.PostalCode("US", "20017"), geonames.PostalCode("US", "20018")}
+		matrix, err := r.Matrix(origins, destinations)
+		assert.Nil(t, err)
+		assert.Len(t, matrix, 2)
+		assert.Len(t, matrix[0], 3)
+
+		d, _ := r.Distance(origins[0], destinations[0])
+		assert.Equal(t, *d, matrix[0][0])
+		assert.Equal(t, 0.0, matrix[0][1].Kilometers)
+		assert.Equal(t, 0.0, matrix[1][0].Kilometers)
+	})
+}
+
+func TestRadar_Schedule(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("bad rule", func(t *testing.T) {
+			s := serve("testdata/sample.zip")
+			r := New(GeonamesLocation(s.URL), RefreshSchedule("FREQ=SOMETIMES"))
+			assert.NotNil(t, r.Error())
+		})
+
+		t.Run("bad rule event", func(t *testing.T) {
+			events := make(chan RefreshError, 1)
+			New(GeonamesSource(unchanged{}), RefreshSchedule("FREQ=SOMETIMES"), ErrorHandler(func(e RefreshError) { events <- e }))
+			e := <-events
+			assert.Equal(t, GeonamesDataset, e.Source)
+			assert.True(t, errors.Is(e, ErrInvalidInput))
+		})
+
+		for _, opt := range []RadarOption{RefreshInterval(0), GeonamesRefreshInterval(-time.Hour)} {
+			opt := opt
+			t.Run("non-positive interval", func(t *testing.T) {
+				events := make(chan RefreshError, 1)
+				r := New(GeonamesSource(unchanged{}), opt, ErrorHandler(func(e RefreshError) { events <- e }))
+				e := <-events
+				assert.Equal(t, GeonamesDataset, e.Source)
+				assert.True(t, errors.Is(e, ErrInvalidInput))
+
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				assert.Nil(t, r.geonamesFeed.schedule)
+				assert.True(t, r.geonamesFeed.next.IsZero())
+			})
+		}
+	})
+
+	t.Run("can refresh on interval", func(t *testing.T) {
+		var hits int32
+		s := count(&hits, "testdata/sample.zip")
+		c := clock{now: time.Date(2021, 11, 15, 0, 0, 0, 0, time.UTC)}
+		r := New(GeonamesLocation(s.URL), RefreshInterval(time.Hour), RefreshJitter(time.Minute), RefreshClock(&c))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+		c.Add(30 * time.Minute)
+		<-time.After(50 * time.Millisecond)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+		c.Add(31 * time.Minute)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&hits) == 2 }, 5*time.Second, 10*time.Millisecond)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		assert.True(t, r.geonamesFeed.next.After(c.Now()))
+	})
+
+	t.Run("can refresh on recurrence rule", func(t *testing.T) {
+		var hits int32
+		s := count(&hits, "testdata/sample.zip")
+		c := clock{now: time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)}
+		r := New(GeonamesLocation(s.URL), RefreshSchedule("FREQ=DAILY;BYHOUR=3;BYMINUTE=0;BYSECOND=0"), RefreshClock(&c))
+		r.mu.Lock()
+		assert.Equal(t, time.Date(2021, 11, 16, 3, 0, 0, 0, time.UTC), r.geonamesFeed.next)
+		r.mu.Unlock()
+
+		c.Add(15 * time.Hour)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&hits) == 2 }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("can refresh each dataset on its own schedule", func(t *testing.T) {
+		s := serve("testdata/sample.zip")
+		c := clock{now: time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)}
+		r := New(
+			GeonamesLocation(s.URL),
+			GeonamesRefreshInterval(time.Hour),
+			MaxmindSource(unchanged{}),
+			MaxmindRefreshSchedule("FREQ=WEEKLY;BYDAY=TU,FR;BYHOUR=3;BYMINUTE=0;BYSECOND=0"),
+			RefreshClock(&c),
+		)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		assert.Equal(t, time.Date(2021, 11, 15, 13, 0, 0, 0, time.UTC), r.geonamesFeed.next)
+		assert.Equal(t, time.Date(2021, 11, 16, 3, 0, 0, 0, time.UTC), r.maxmindFeed.next)
+	})
+}
+
+func TestRadar_Ready(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("expired context", func(t *testing.T) {
+			s := serve("testdata/sample.zip")
+			r := New(GeonamesLocation(s.URL), MaxmindLocation(s.URL))
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+			assert.NotNil(t, r.WaitReady(ctx))
+		})
+
+		t.Run("closed", func(t *testing.T) {
+			s := serve("testdata/sample.zip")
+			r := New(GeonamesLocation(s.URL), MaxmindLocation(s.URL))
+			assert.Nil(t, r.Close(context.TODO()))
+			assert.True(t, errors.Is(r.WaitReady(context.TODO()), ErrClosed))
+		})
+	})
+
+	t.Run("is ready after blocking start", func(t *testing.T) {
+		s := serve("testdata/sample.zip")
+		r := New(GeonamesLocation(s.URL))
+		assert.Nil(t, r.WaitReady(context.TODO()))
+	})
+
+	t.Run("can start asynchronously", func(t *testing.T) {
+		release := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			http.ServeFile(w, r, "testdata/sample.zip")
+		}))
+
+		r := New(GeonamesLocation(s.URL), AsyncStart())
+		select {
+		case <-r.Ready():
+			t.Fatal("ready before datasets were loaded")
+		default:
+		}
+
+		_, err := r.Postal("US", "20017")
+		assert.True(t, errors.Is(err, ErrNotReady))
+
+		close(release)
+		assert.Nil(t, r.WaitReady(context.TODO()))
+		loc, err := r.Postal("US", "20017")
+		assert.Nil(t, err)
+		assert.NotNil(t, loc)
+	})
+}
+
+func TestRadar_Status(t *testing.T) {
+	t.Parallel()
+
+	s := serve("testdata/sample.zip")
+
+	t.Run("reports failures", func(t *testing.T) {
+		r := New(GeonamesLocation(s.URL), MaxmindLocation(s.URL))
+		status := r.Status()
+		assert.False(t, status.Geonames.LastSuccess.IsZero())
+		assert.True(t, status.Maxmind.LastSuccess.IsZero())
+		assert.False(t, status.Maxmind.LastFailure.IsZero())
+		assert.NotNil(t, status.Maxmind.LastError)
+		assert.Zero(t, status.Maxmind.Count)
+	})
+
+	t.Run("reports datasets", func(t *testing.T) {
+		mxm := serve("testdata/GeoLite2-City.tgz")
+		r := New(GeonamesLocation(s.URL), MaxmindLocation(mxm.URL+"/?license_key=YOUR_LICENSE_KEY"), MaxmindKey("secret"))
+		status := r.Status()
+		assert.Equal(t, s.URL, status.Geonames.Source)
+		assert.Equal(t, r.loadGeonames().LocationCount, status.Geonames.Count)
+		assert.Positive(t, status.Geonames.Size)
+		assert.NotEmpty(t, status.Geonames.Validator.LastModified)
+		assert.False(t, status.Geonames.LastSuccess.IsZero())
+		assert.True(t, status.Geonames.LastFailure.IsZero())
+		assert.Nil(t, status.Geonames.LastError)
+
+		assert.NotContains(t, status.Maxmind.Source, "secret")
+		assert.Positive(t, status.Maxmind.Count)
+		assert.Equal(t, "GeoIP2-City", status.Maxmind.DatabaseType)
+		assert.False(t, status.Maxmind.BuildEpoch.IsZero())
+		assert.Positive(t, status.Maxmind.Size)
+	})
+}
+
+func TestRadar_OnError(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reports stage of geonames failures", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("bad body"))
+		}))
+
+		events := make(chan RefreshError, 2)
+		r := New(GeonamesLocation(s.URL), ErrorHandler(func(e RefreshError) { events <- e }))
+		e := <-events
+		assert.Equal(t, GeonamesDataset, e.Source)
+		assert.Equal(t, StageDecompress, e.Stage)
+		assert.False(t, e.Time.IsZero())
+		assert.NotNil(t, e.Err)
+		assert.Contains(t, e.Error(), "geonames decompress")
+
+		subscribed := make(chan RefreshError, 1)
+		r.OnError(func(e RefreshError) { subscribed <- e })
+		r.Refresh()
+		assert.Equal(t, GeonamesDataset, (<-events).Source)
+		assert.Equal(t, GeonamesDataset, (<-subscribed).Source)
+	})
+
+	t.Run("reports stage of maxmind failures", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+
+		events := make(chan RefreshError, 1)
+		New(GeonamesSource(unchanged{}), MaxmindLocation(s.URL), ErrorHandler(func(e RefreshError) { events <- e }))
+		e := <-events
+		assert.Equal(t, MaxmindDataset, e.Source)
+		assert.Equal(t, StageDownload, e.Stage)
+	})
+
+	t.Run("delivers in order without blocking", func(t *testing.T) {
+		release := make(chan struct{})
+		var received []int
+		done := make(chan struct{})
+		r := Radar{clock: systemClock{}, errors: make(chan error, 1)}
+		r.OnError(func(e RefreshError) {
+			<-release
+			received = append(received, len(received))
+			if len(received) == 3 {
+				close(done)
+			}
+		})
+
+		for i := 0; i < 3; i++ {
+			r.fail(GeonamesDataset, "", tea.Err("an error has occurred"))
+		}
+
+		close(release)
+		<-done
+		assert.Equal(t, []int{0, 1, 2}, received)
+		assert.Equal(t, "geonames refresh: an error has occurred", RefreshError{Source: GeonamesDataset, Err: tea.Err("an error has occurred")}.Error())
+	})
+}
+
+func TestRadar_OnRefresh(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sample.zip")
+	b, _ := os.ReadFile("testdata/sample.zip")
+	_ = os.WriteFile(path, b, 0600)
+
+	r := New(GeonamesSource(source.File(path)))
+	starts := make(chan RefreshStart, 1)
+	successes := make(chan RefreshSuccess, 1)
+	failures := make(chan RefreshError, 1)
+	r.OnRefreshStart(func(e RefreshStart) { starts <- e })
+	r.OnRefreshSuccess(func(e RefreshSuccess) { successes <- e })
+	r.OnRefreshFailed(func(e RefreshError) { failures <- e })
+
+	t.Run("skips unchanged datasets", func(t *testing.T) {
+		r.Refresh()
+		assert.Equal(t, GeonamesDataset, (<-starts).Source)
+		assert.Empty(t, successes)
+	})
+
+	t.Run("reports replaced datasets", func(t *testing.T) {
+		modified := time.Now().Add(time.Hour)
+		_ = os.Chtimes(path, modified, modified)
+		r.Refresh()
+
+		start := <-starts
+		assert.Equal(t, GeonamesDataset, start.Source)
+		assert.False(t, start.Time.IsZero())
+
+		success := <-successes
+		assert.Equal(t, GeonamesDataset, success.Source)
+		assert.False(t, success.Time.IsZero())
+		assert.Equal(t, 2898, success.OldCount)
+		assert.Equal(t, 2898, success.NewCount)
+		assert.NotEqual(t, success.OldVersion, success.NewVersion)
+		assert.Equal(t, r.loadGeonames().Validator, success.NewVersion)
+	})
+
+	t.Run("reports failures", func(t *testing.T) {
+		_ = os.Remove(path)
+		r.Refresh()
+		<-starts
+		failure := <-failures
+		assert.Equal(t, GeonamesDataset, failure.Source)
+		assert.Equal(t, StageDownload, failure.Stage)
+	})
+
+	t.Run("reports the initial refresh", func(t *testing.T) {
+		starts := make(chan RefreshStart, 1)
+		successes := make(chan RefreshSuccess, 1)
+		r := New(
+			GeonamesSource(source.File("testdata/sample.zip")),
+			RefreshStartHandler(func(e RefreshStart) { starts <- e }),
+			RefreshSuccessHandler(func(e RefreshSuccess) { successes <- e }),
+		)
+
+		assert.Equal(t, GeonamesDataset, (<-starts).Source)
+		success := <-successes
+		assert.Equal(t, GeonamesDataset, success.Source)
+		assert.Zero(t, success.OldCount)
+		assert.Equal(t, r.loadGeonames().LocationCount, success.NewCount)
+	})
+}
+
+func TestRadar_Validate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keeps the previous dataset", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sample.zip")
+		b, _ := os.ReadFile("testdata/sample.zip")
+		_ = os.WriteFile(path, b, 0600)
+
+		var reject int32
+		errTooFew := errors.New("too few postal codes")
+		events := make(chan RefreshError, 1)
+		r := New(
+			GeonamesSource(source.File(path)),
+			RequiredCountries("us"),
+			ValidateGeonames(func(gc *geonames.Client) error {
+				if atomic.LoadInt32(&reject) == 1 {
+					return errTooFew
+				}
+
+				return nil
+			}),
+			ErrorHandler(func(e RefreshError) { events <- e }),
+		)
+
+		gc := r.loadGeonames()
+		assert.NotNil(t, gc)
+
+		atomic.StoreInt32(&reject, 1)
+		modified := time.Now().Add(time.Hour)
+		_ = os.Chtimes(path, modified, modified)
+		err := r.Refresh()
+		assert.True(t, errors.Is(err, ErrRejected))
+		assert.True(t, errors.Is(err, errTooFew))
+		assert.Contains(t, err.Error(), "too few postal codes")
+		assert.Same(t, gc, r.loadGeonames())
+
+		e := <-events
+		assert.Equal(t, GeonamesDataset, e.Source)
+		assert.Equal(t, StageValidate, e.Stage)
+		assert.True(t, errors.Is(e, errTooFew))
+
+		var le *Error
+		assert.True(t, errors.As(e, &le))
+		assert.Equal(t, ErrRejected, le.Kind)
+		assert.False(t, r.Status().Geonames.LastFailure.IsZero())
+	})
+
+	t.Run("rejects missing countries", func(t *testing.T) {
+		s := serve("testdata/sample.zip")
+		r := New(GeonamesLocation(s.URL), RequiredCountries("us", "de"))
+		assert.Nil(t, r.loadGeonames())
+		assert.True(t, errors.Is(r.Refresh(), ErrRejected))
+	})
+
+	t.Run("rejects small datasets", func(t *testing.T) {
+		mxm := serve("testdata/GeoLite2-City.tgz")
+		r := New(GeonamesSource(unchanged{}), MaxmindLocation(mxm.URL), MaxmindMinCount(math.MaxInt32))
+
+		var e RefreshError
+		assert.True(t, errors.As(r.RefreshMaxmind(), &e))
+		assert.Equal(t, MaxmindDataset, e.Source)
+		assert.Equal(t, StageValidate, e.Stage)
+		assert.Nil(t, r.loadMaxmind())
+	})
+
+	t.Run("rejects shrinking datasets", func(t *testing.T) {
+		f := feed{dataset: GeonamesDataset, maxShrink: 0.1}
+		assert.Nil(t, f.validate(0, 1))
+		assert.Nil(t, f.validate(100, 90))
+		assert.True(t, errors.Is(f.validate(100, 89), ErrRejected))
+	})
+}
+
+func TestRadar_Rollback(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sample.zip")
+	b, _ := os.ReadFile("testdata/sample.zip")
+	_ = os.WriteFile(path, b, 0600)
+
+	touch := func(d time.Duration) {
+		modified := time.Now().Add(d)
+		_ = os.Chtimes(path, modified, modified)
+	}
+
+	r := New(GeonamesSource(source.File(path)), DrainTimeout(time.Millisecond))
+	first := r.loadGeonames()
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("no previous version", func(t *testing.T) {
+			assert.True(t, errors.Is(r.Rollback(), ErrNoPrevious))
+		})
+	})
+
+	t.Run("can roll back", func(t *testing.T) {
+		touch(time.Hour)
+		assert.Nil(t, r.Refresh())
+		second := r.loadGeonames()
+		assert.NotSame(t, first, second)
+		assert.Equal(t, first.Validator, r.Status().Geonames.Previous)
+
+		assert.Nil(t, r.Rollback())
+		assert.Same(t, first, r.loadGeonames())
+		status := r.Status()
+		assert.True(t, status.Geonames.RolledBack)
+		assert.Equal(t, first.Validator, status.Geonames.Validator)
+		assert.Zero(t, status.Geonames.Previous)
+		assert.Eventually(t, func() bool {
+			_, err := second.Get(geonames.PostalCode("US", "20017"))
+			return errors.Is(err, ErrClosed)
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("can disable retention", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sample.zip")
+		_ = os.WriteFile(path, b, 0600)
+
+		r := New(GeonamesSource(source.File(path)), DrainTimeout(time.Millisecond), DisableRollback())
+		first := r.loadGeonames()
+		modified := time.Now().Add(time.Hour)
+		_ = os.Chtimes(path, modified, modified)
+		assert.Nil(t, r.Refresh())
+		assert.NotSame(t, first, r.loadGeonames())
+		assert.Zero(t, r.Status().Geonames.Previous)
+		assert.True(t, errors.Is(r.Rollback(), ErrNoPrevious))
+		assert.Eventually(t, func() bool {
+			_, err := first.Get(geonames.PostalCode("US", "20017"))
+			return errors.Is(err, ErrClosed)
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("skips rolled back versions", func(t *testing.T) {
+		assert.Nil(t, r.Refresh())
+		assert.Same(t, first, r.loadGeonames())
+
+		touch(2 * time.Hour)
+		assert.Nil(t, r.Refresh())
+		assert.NotSame(t, first, r.loadGeonames())
+		assert.False(t, r.Status().Geonames.RolledBack)
+	})
+}
+
+func TestRadar_Mirrors(t *testing.T) {
+	t.Parallel()
+
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("every mirror failed", func(t *testing.T) {
+			events := make(chan RefreshError, 2)
+			r := New(
+				GeonamesSource(unchanged{}),
+				MaxmindMirrors(Mirror{Location: forbidden.URL}, Mirror{Location: hanging.URL, Timeout: 10 * time.Millisecond}),
+				ErrorHandler(func(e RefreshError) { events <- e }),
+			)
+
+			failover := <-events
+			assert.Equal(t, forbidden.URL, failover.Origin)
+			assert.True(t, failover.Failover)
+
+			failure := <-events
+			assert.Equal(t, hanging.URL, failure.Origin)
+			assert.False(t, failure.Failover)
+			assert.Contains(t, failure.Error(), "deadline exceeded")
+			assert.Nil(t, r.loadMaxmind())
+		})
+	})
+
+	t.Run("can fail over", func(t *testing.T) {
+		s := serve("testdata/sample.zip")
+		events := make(chan RefreshError, 2)
+		r := New(
+			GeonamesMirrors(
+				Mirror{Location: hanging.URL, Timeout: 10 * time.Millisecond},
+				Mirror{Location: forbidden.URL},
+				Mirror{Location: s.URL, Timeout: time.Minute},
+			),
+			ErrorHandler(func(e RefreshError) { events <- e }),
+		)
+
+		assert.NotNil(t, r.loadGeonames())
+		assert.Nil(t, r.Error())
+		assert.Equal(t, hanging.URL, r.Status().Geonames.Source)
+		assert.Equal(t, hanging.URL, (<-events).Origin)
+
+		e := <-events
+		assert.Equal(t, forbidden.URL, e.Origin)
+		assert.Equal(t, GeonamesDataset, e.Source)
+		assert.Equal(t, StageDownload, e.Stage)
+		assert.True(t, e.Failover)
+	})
+}
+
+func TestRadar_HTTP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("proxy on custom transport", func(t *testing.T) {
+			var calls int32
+			transport := roundTripper(func(r *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&calls, 1)
+				return http.DefaultTransport.RoundTrip(r)
+			})
+
+			events := make(chan RefreshError, 1)
+			New(
+				GeonamesSource(unchanged{}),
+				MaxmindLocation("http://download.example.com/GeoLite2-City.tar.gz"),
+				Transport(transport),
+				Proxy(http.ProxyFromEnvironment),
+				ErrorHandler(func(e RefreshError) { events <- e }),
+			)
+
+			e := <-events
+			assert.Equal(t, MaxmindDataset, e.Source)
+			assert.Contains(t, e.Error(), "proxy can not be set")
+			assert.Zero(t, atomic.LoadInt32(&calls))
+		})
+	})
+
+	t.Run("can tag downloads", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+			w.WriteHeader(http.StatusForbidden)
+		}))
+
+		New(GeonamesSource(unchanged{}), MaxmindLocation(s.URL), UserAgent("service/1.0"), Header("X-Service", "way"))
+		r := <-requests
+		assert.Equal(t, "service/1.0", r.Header.Get("User-Agent"))
+		assert.Equal(t, "way", r.Header.Get("X-Service"))
+	})
+
+	t.Run("can retry downloads", func(t *testing.T) {
+		var calls int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			http.ServeFile(w, r, "testdata/sample.zip")
+		}))
+
+		r := New(GeonamesLocation(s.URL), Retries(1), Backoff(time.Millisecond, time.Millisecond))
+		assert.Nil(t, r.Error())
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("can disable retries", func(t *testing.T) {
+		var calls int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+
+		r := New(GeonamesLocation(s.URL), Retries(0))
+		assert.NotNil(t, r.Error())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("can use a custom transport", func(t *testing.T) {
+		var calls int32
+		transport := roundTripper(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return &http.Response{StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+		})
+
+		New(GeonamesSource(unchanged{}), MaxmindLocation("https://download.example.com/GeoLite2-City.tar.gz"), HTTPClient(&http.Client{}), Transport(transport))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("can use an authenticated proxy", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+			w.WriteHeader(http.StatusForbidden)
+		}))
+
+		u, _ := url.Parse(proxy.URL)
+		u.User = url.UserPassword("egress", "secret")
+		New(GeonamesSource(unchanged{}), MaxmindLocation("http://download.example.com/GeoLite2-City.tar.gz"), Proxy(http.ProxyURL(u)))
+		r := <-requests
+		assert.Equal(t, "download.example.com", r.Host)
+		assert.NotEmpty(t, r.Header.Get("Proxy-Authorization"))
+	})
+}
+
+func TestRadar_MaxmindAccount(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("without leaking secrets", func(t *testing.T) {
+			transport := roundTripper(func(r *http.Request) (*http.Response, error) {
+				return nil, tea.Err("connection failed")
+			})
+
+			events := make(chan RefreshError, 1)
+			New(GeonamesSource(unchanged{}), MaxmindKey("secret"), Transport(transport), ErrorHandler(func(e RefreshError) { events <- e }))
+			e := <-events
+			assert.Contains(t, e.Error(), "connection failed")
+			assert.Contains(t, e.Error(), "license_key=REDACTED")
+			assert.NotContains(t, e.Error(), "secret")
+			assert.NotContains(t, fmt.Sprintf("%+v", e.Err), "secret")
+			assert.NotContains(t, e.Origin, "secret")
+		})
+	})
+
+	t.Run("can download with an account", func(t *testing.T) {
+		bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			http.ServeFile(w, r, "testdata/GeoLite2-City.tgz")
+		}))
+
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "42" || password != "secret" || r.URL.Path != "/geoip/databases/GeoIP2-City/download" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			http.Redirect(w, r, bucket.URL+"/GeoIP2-City.tar.gz", http.StatusFound)
+		}))
+
+		transport := roundTripper(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Host == "download.maxmind.com" {
+				r = r.Clone(r.Context())
+				r.URL.Scheme, r.URL.Host = "http", api.Listener.Addr().String()
+			}
+
+			return http.DefaultTransport.RoundTrip(r)
+		})
+
+		r := New(GeonamesSource(unchanged{}), MaxmindAccount("42", "secret"), MaxmindEdition("GeoIP2-City"), Transport(transport))
+		assert.NotNil(t, r.loadMaxmind())
+		assert.Equal(t, "https://download.maxmind.com/geoip/databases/GeoIP2-City/download?suffix=tar.gz", r.Status().Maxmind.Source)
+	})
+}
+
+func TestRadar_Close(t *testing.T) {
+	// not parallel, the temp dir is set for the whole process
+	s := serve("testdata/sample.zip")
+
+	t.Run("should notify on errors", func(t *testing.T) {
+		t.Run("expired context", func(t *testing.T) {
+			r := New(GeonamesLocation(s.URL))
+			r.running.Add(1)
+			defer r.running.Done()
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+			assert.NotNil(t, r.Close(ctx))
+		})
+	})
+
+	t.Run("cancels pending refreshes", func(t *testing.T) {
+		s, hang := block("testdata/sample.zip")
+		r := New(GeonamesLocation(s.URL))
+		hang()
+
+		h := r.RefreshAsync(context.TODO())
+		assert.Nil(t, r.Close(context.TODO()))
+		assert.NotNil(t, h.Wait())
+	})
+
+	t.Run("races with abandoned refreshes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		for i := 0; i < 50; i++ {
+			r := New(GeonamesSource(unchanged{}))
+			h := r.RefreshAsync(ctx)
+			assert.Nil(t, r.Close(context.TODO()))
+			<-h.Done()
+		}
+	})
+
+	t.Run("can close", func(t *testing.T) {
+		r := New(GeonamesLocation(s.URL))
+		gc := r.loadGeonames()
+		assert.Nil(t, r.Close(context.TODO()))
+		assert.Nil(t, r.Close(context.TODO()))
+		assert.Nil(t, r.loadGeonames())
+
+		_, err := gc.Get(geonames.PostalCode("US", "20017"))
+		assert.NotNil(t, err)
+
+		_, err = r.Postal("US", "20017")
+		assert.True(t, errors.Is(err, ErrClosed))
+
+		_, err = r.IP("81.2.69.142")
+		assert.True(t, errors.Is(err, ErrClosed))
+		assert.True(t, errors.Is(r.Rollback(), ErrClosed))
+
+		_, err = r.Nearest(geonames.Coordinate{}, 1)
+		assert.True(t, errors.Is(err, ErrClosed))
+
+		assert.True(t, errors.Is(r.Refresh(), ErrClosed))
+		assert.True(t, errors.Is(r.RefreshAsync(context.TODO()).Wait(), ErrClosed))
+		assert.Nil(t, r.loadGeonames())
+	})
+
+	t.Run("releases draining datasets", func(t *testing.T) {
+		dir, data := t.TempDir(), t.TempDir()
+		t.Setenv("TMPDIR", dir)
+
+		path := filepath.Join(data, "GeoLite2-City.tgz")
+		b, _ := os.ReadFile("testdata/GeoLite2-City.tgz")
+		_ = os.WriteFile(path, b, 0600)
+
+		r := New(GeonamesSource(unchanged{}), MaxmindSource(source.File(path)))
+		first := r.loadMaxmind()
+		for i := 1; i <= 2; i++ {
+			modified := time.Now().Add(time.Duration(i) * time.Hour)
+			_ = os.Chtimes(path, modified, modified)
+			assert.Nil(t, r.RefreshMaxmind())
+		}
+
+		_, err := first.Get(net.ParseIP("81.2.69.142"))
+		assert.Nil(t, err)
+
+		assert.Nil(t, r.Close(context.TODO()))
+		_, err = first.Get(net.ParseIP("81.2.69.142"))
+		assert.True(t, errors.Is(err, ErrClosed))
+		files, _ := os.ReadDir(dir)
+		assert.Empty(t, files)
+	})
+}
+
+// unchanged is a source that never changes
+type unchanged struct{}
+
+func (unchanged) Open(context.Context, client.Validator) (io.ReadCloser, client.Validator, error) {
+	return nil, client.Validator{}, tea.Stacktrace(client.ErrNotModified)
+}
+
+// gated is a source that never changes, opening once per value received (or after it is closed), regardless of the context
+type gated chan struct{}
+
+func (g gated) Open(context.Context, client.Validator) (io.ReadCloser, client.Validator, error) {
+	<-g
+	return nil, client.Validator{}, tea.Stacktrace(client.ErrNotModified)
+}
+
+// blocking is a server that can be made to hang until released
+type blocking struct {
+	*httptest.Server
+	hits int32
+}
+
+// block serves a file, hanging requests after hang is called until they are released (or canceled)
+func block(path string) (*blocking, func() (release func())) {
+	var hanging atomic.Value
+	b := blocking{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&b.hits, 1)
+		if release, _ := hanging.Load().(chan struct{}); release != nil {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		http.ServeFile(w, r, path)
+	}))
+
+	return &b, func() func() {
+		release := make(chan struct{})
+		hanging.Store(release)
+		return func() { close(release) }
+	}
+}
+
+// clock is a manually advanced clock
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func count(hits *int32, path string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		http.ServeFile(w, r, path)
+	}))
+}
+
+func stream(path string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := os.ReadFile(path)
+		w.Write(b)
+	}))
+}
+
 func serve(path string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, path)
 	}))
 }
+
+// roundTripper is a function implementing http.RoundTripper
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (fn roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
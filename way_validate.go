@@ -0,0 +1,113 @@
+package way
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pghq/go-way/country"
+	"github.com/pghq/go-way/geonames"
+	"github.com/pghq/go-way/internal/errs"
+	"github.com/pghq/go-way/maxmind"
+)
+
+// validate the record count of a refreshed dataset against the minimum and the count of the current dataset (zero if there is none)
+func (f *feed) validate(current, count int) error {
+	if count < f.minCount {
+		return errs.New(ErrRejected, fmt.Sprintf("%s has %d records, at least %d required", f.dataset, count, f.minCount))
+	}
+
+	if current > 0 && f.maxShrink > 0 && float64(count) < float64(current)*(1-f.maxShrink) {
+		return errs.New(ErrRejected, fmt.Sprintf("%s shrank from %d to %d records", f.dataset, current, count))
+	}
+
+	return nil
+}
+
+// validateGeonames checks a refreshed geonames db before it replaces the current one
+func (r *Radar) validateGeonames(gc *geonames.Client) error {
+	var current int
+	if old := r.loadGeonames(); old != nil {
+		current = old.LocationCount
+	}
+
+	if err := r.geonamesFeed.validate(current, gc.LocationCount); err != nil {
+		return err
+	}
+
+	for _, code := range r.requiredCountries {
+		if gc.CountryCounts[country.Country(strings.ToUpper(code))] == 0 {
+			return errs.New(ErrRejected, fmt.Sprintf("geonames has no locations for required country %s", strings.ToUpper(code)))
+		}
+	}
+
+	for _, fn := range r.geonamesValidators {
+		if err := fn(gc); err != nil {
+			return errs.Wrap(ErrRejected, err, "geonames failed validation")
+		}
+	}
+
+	return nil
+}
+
+// validateMaxmind checks a refreshed maxmind db before it replaces the current one
+func (r *Radar) validateMaxmind(mc *maxmind.Client) error {
+	var current int
+	if old := r.loadMaxmind(); old != nil {
+		current = old.IPCount
+	}
+
+	if err := r.maxmindFeed.validate(current, mc.IPCount); err != nil {
+		return err
+	}
+
+	for _, fn := range r.maxmindValidators {
+		if err := fn(mc); err != nil {
+			return errs.Wrap(ErrRejected, err, "maxmind failed validation")
+		}
+	}
+
+	return nil
+}
+
+// GeonamesMinCount rejects refreshed geonames dbs with fewer locations
+func GeonamesMinCount(o int) RadarOption {
+	return func(r *Radar) {
+		r.geonamesFeed.minCount = o
+	}
+}
+
+// MaxmindMinCount rejects refreshed maxmind dbs with fewer network nodes
+func MaxmindMinCount(o int) RadarOption {
+	return func(r *Radar) {
+		r.maxmindFeed.minCount = o
+	}
+}
+
+// MaxShrink rejects refreshed datasets that shrank by more than a fraction (e.g., 0.1 for 10%) of the current dataset
+func MaxShrink(o float64) RadarOption {
+	return func(r *Radar) {
+		r.geonamesFeed.maxShrink = o
+		r.maxmindFeed.maxShrink = o
+	}
+}
+
+// RequiredCountries rejects refreshed geonames dbs without locations for each of the countries
+func RequiredCountries(o ...string) RadarOption {
+	return func(r *Radar) {
+		r.requiredCountries = o
+	}
+}
+
+// ValidateGeonames rejects refreshed geonames dbs that fail a custom check
+func ValidateGeonames(fn func(gc *geonames.Client) error) RadarOption {
+	return func(r *Radar) {
+		r.geonamesValidators = append(r.geonamesValidators, fn)
+	}
+}
+
+// ValidateMaxmind rejects refreshed maxmind dbs that fail a custom check
+func ValidateMaxmind(fn func(mc *maxmind.Client) error) RadarOption {
+	return func(r *Radar) {
+		r.maxmindValidators = append(r.maxmindValidators, fn)
+	}
+}